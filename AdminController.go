@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+	"io"
+	"github.com/gorilla/mux"
+)
+
+// Exposes operational information about the service.  The GET endpoints are
+// read only and safe to scrape from monitoring, anything which changes state
+// is wrapped in RequireAdmin in routes.go
+type AdminController struct {
+	Controller
+}
+
+// Returns the current queue depth and worker utilisation of the pool
+func (c *AdminController) poolStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Workers.Stats())
+}
+
+// Returns the number of pending and dead-lettered deliveries per destination
+func (c *AdminController) outboxBacklog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Deliveries.Backlog())
+}
+
+// Moves the dead-lettered deliveries for a destination back onto the queue
+func (c *AdminController) requeueDeadDeliveries(w http.ResponseWriter, r *http.Request) {
+	destination := mux.Vars(r)["destination"]
+	requeued, err := Deliveries.Requeue(destination)
+	if err != nil {
+		http.Error(w, "Failed to requeue dead deliveries: " + err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"requeued": requeued})
+}
+
+// Summarises where the shadow parser disagrees with the active parser over
+// the last ?hours= (24 by default), with the ?limit= most recent diffs
+func (c *AdminController) shadowParserDiffs(w http.ResponseWriter, r *http.Request) {
+	hours, err := strconv.Atoi(r.URL.Query().Get("hours"))
+	if err != nil || hours <= 0 {
+		hours = 24
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Shadow.Summary(hours, limit))
+}
+
+// Lists the ?limit= (20 by default) most recently queued reprocess jobs with
+// their progress
+func (c *AdminController) reprocessJobs(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Reprocess.Jobs(limit))
+}
+
+// Queues a job to re-parse the stored lines for a server, an item and a
+// from/to range ("2006-01-02" or "2006-01-02 15:04:05"), all optional
+func (c *AdminController) queueReprocessJob(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Server string `json:"server"`
+		Item   string `json:"item"`
+		From   string `json:"from"`
+		To     string `json:"to"`
+	}
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	job := ReprocessJob{Server: strings.ToUpper(strings.TrimSpace(request.Server)), Item: strings.TrimSpace(request.Item)}
+	if job.Server != "" && job.Server != "RED" && job.Server != "BLUE" {
+		http.Error(w, "Unknown server: " + job.Server, 400)
+		return
+	}
+	var err error
+	if job.From, err = parseJobTime(request.From); err != nil {
+		http.Error(w, "Invalid from time: " + request.From, 400)
+		return
+	}
+	if job.To, err = parseJobTime(request.To); err != nil {
+		http.Error(w, "Invalid to time: " + request.To, 400)
+		return
+	}
+
+	job, err = Reprocess.Queue(job)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// Reads a date or date and time as a DATETIME value, empty stays empty
+func parseJobTime(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	parsed, err := time.Parse("2006-01-02 15:04:05", value)
+	if err != nil {
+		parsed, err = time.Parse("2006-01-02", value)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return parsed.Format("2006-01-02 15:04:05"), nil
+}
+
+// Returns a reprocess job and its progress
+func (c *AdminController) reprocessJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job id", 400)
+		return
+	}
+	job, err := Reprocess.Job(id)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// Cancels a queued or running reprocess job, rows already replaced stay replaced
+func (c *AdminController) cancelReprocessJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job id", 400)
+		return
+	}
+	cancelled, err := Reprocess.Cancel(id)
+	if err != nil {
+		http.Error(w, "Failed to cancel the job: " + err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"cancelled": cancelled})
+}
+
+// Reports ok, or degraded along with the warnings raised by any component
+// which is running in a fallback mode.  Degraded still answers 200 as the
+// service is accepting and processing uploads
+func (c *AdminController) health(w http.ResponseWriter, r *http.Request) {
+	warnings := []string{}
+	if warner, ok := Dedup.(HealthWarner); ok {
+		warnings = append(warnings, warner.Warnings()...)
+	}
+
+	status := "ok"
+	if len(warnings) > 0 {
+		status = "degraded"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "warnings": warnings})
+}
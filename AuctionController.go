@@ -7,34 +7,35 @@ import (
 	"strings"
 	"regexp"
 	"hash/fnv"
-	"github.com/bradfitz/gomemcache/memcache"
-	"sync"
 	"bytes"
 	"io/ioutil"
 	"time"
-	"github.com/fvbock/trie"
 	"errors"
 	"github.com/alexmk92/stringutil"
+	"strconv"
 )
 
-type WalkResult struct {
-	match bool
-	searchTerm string
-	value string
+type AuctionController struct {
+	Controller
+	catalog catalogHolder
 }
 
-func (w *WalkResult) reset() {
-	w.match = false
-	w.searchTerm = ""
-	w.value = ""
-}
+// Matches a log line of an auction, e.g. "[Mon Jan 2 15:04:05 2006] Soandso auctions, '...'"
+var auctionLineRegex = regexp.MustCompile(`(\[)([a-zA-Z0-9: ]+)(] ([A-Za-z]+) ((auction)|(auctions)))`)
 
-type AuctionController struct {
-	Controller
-	ItemTrie *trie.Trie
-	WalkResult WalkResult
+// Splits an auction line into its timestamp, seller and the text inside the quotes
+var auctionPartsRegex = regexp.MustCompile(`(?m)^\[(?P<Timestamp>[A-Za-z0-9: ]+)+] (?P<Seller>[A-Za-z]+) auction[s]?, '(?P<Items>.+)'$`)
+
+// The keywords which switch the intent of the items that follow
+var intentWords = map[string]string{
+	"wts": INTENT_SELL, "selling": INTENT_SELL,
+	"wtt": INTENT_TRADE, "trading": INTENT_TRADE, "trade for": INTENT_TRADE,
+	"wtb": INTENT_BUY, "buying": INTENT_BUY,
 }
 
+// The keywords which say the price before them was for each of the items
+var unitWords = map[string]bool{"ea": true, "each": true, "per": true}
+
 // Receive a list of auction lines from the Log client
 func (c *AuctionController) store(w http.ResponseWriter, r *http.Request) {
 	// Get api key and email
@@ -57,32 +58,10 @@ func (c *AuctionController) store(w http.ResponseWriter, r *http.Request) {
 	characterName = strings.Title(characterName)
 
 	// Forward to the gatekeeper to see if this pair of items match
-	req, err := http.NewRequest("GET", "http://" + GATEKEEPER_SERVICE_HOST + ":" + GATEKEEPER_SERVICE_PORT + "/auth", nil)
-	if err != nil {
-		http.Error(w, "Couldn't contact the gatekeeper service", 500)
-		return
-	}
-	req.Header.Set("apiKey", apiKey)
-	req.Header.Set("email", email)
-
-	var client = &http.Client {
-		Timeout: time.Second * 10,
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Could not reach the gatekeeper service", 500)
+	if status, body := Authenticate(apiKey, email); status != 200 {
+		fmt.Println("Response from gatekeeper service: ", status, body)
+		http.Error(w, body, status)
 		return
-	} else {
-		defer resp.Body.Close()
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-
-		if resp.StatusCode != 200 {
-			fmt.Println("Response from wiki service: ", resp)
-			http.Error(w, bodyString, resp.StatusCode)
-			return
-		}
 	}
 
 	// Do the auction processing
@@ -91,29 +70,83 @@ func (c *AuctionController) store(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Please send a request body", 400)
 		return
 	}
-	err = json.NewDecoder(r.Body).Decode(&auctions)
+	// Bytes which aren't UTF-8 are escaped so the decoder doesn't replace them,
+	// and put back in each line for NormaliseLine to decode, see normalise.go
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
+	err = json.Unmarshal(EscapeInvalidUTF8(body), &auctions)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	for i, line := range auctions.Lines {
+		auctions.Lines[i] = UnescapeInvalidUTF8(line)
+	}
 
 	if len(auctions.Lines) == 0 {
 		http.Error(w, "No lines were present in the auctions array", 400)
 		return
 	}
+	auctions.received = time.Now()
 
-	go c.parse(&auctions, characterName, serverType)
+	// Log timestamps are moved onto our clock only when the client tells us its
+	// UTC offset, see RawAuctions.ClockOffset
+	if utcOffset := strings.TrimSpace(r.Header.Get("utcOffset")); utcOffset != "" {
+		auctions.clientZone, err = ParseUTCOffset(utcOffset)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	// Queue the upload on the worker pool, if the pool is saturated we tell the
+	// client to back off and resend later instead of accepting unbounded work
+	if !Workers.TrySubmit(func() { c.parse(&auctions, characterName, serverType) }) {
+		w.Header().Set("Retry-After", fmt.Sprint(WORKER_RETRY_AFTER_IN_SECS))
+		http.Error(w, "The collection service is busy, please retry later", 503)
+		return
+	}
+}
+
+// Lists the auction event schema versions we can publish and the version each
+// publisher is currently being sent, consumers move to a new version by
+// having it set for their publisher in PUBLISHER_SCHEMA_VERSIONS
+func (c *AuctionController) schema(w http.ResponseWriter, r *http.Request) {
+	publishers := map[string]int{}
+	for _, p := range Publishers {
+		publishers[p.Name()] = SchemaVersionFor(p.Name())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"current": AUCTION_EVENT_VERSION,
+		"supported": SUPPORTED_AUCTION_EVENT_VERSIONS,
+		"publishers": publishers,
+	})
 }
 
 func (c *AuctionController) isAuctionLine(line *string) bool {
-	isValid, _ := regexp.MatchString("(\\[)([a-zA-Z0-9: ]+)(] ([A-Za-z]+) ((auction)|(auctions)))", *line)
-	return isValid
+	return auctionLineRegex.MatchString(*line)
 }
 
 
-// Gets a unique hash of the auction string and checks if it exists in memcached,
-// if it does we parse the line, else we skip it
-func (c *AuctionController) shouldParse(line *string, server string) bool {
+// Gets a unique hash of the auction string and checks whether another client
+// already uploaded the same line at (about) the same log time, if not we parse
+// the line, else we skip it.
+//
+// Lines are keyed on the timestamp from the log rather than when they arrive,
+// so a seller repeating the same auction later on is a new sighting while the
+// same auction uploaded by ten clients, or uploaded late from an old log, is
+// merged.  The log timestamp (corrected onto our clock) is bucketed into
+// windows of DEDUP_WINDOW_IN_SECS for the server, the first client to claim a
+// bucket wins.  A sighting either side of a bucket boundary claims both
+// buckets, so a claim which finds a sighting within the window in the bucket
+// before or after defers to it if that one was claimed first.  When two claims
+// race they both see each other and agree on which one parses
+func (c *AuctionController) shouldParse(line *string, server string, timestamp time.Time) bool {
 
 	// Create a 64bit hash key from this string
 	hash := func(ln string) uint64 {
@@ -122,78 +155,213 @@ func (c *AuctionController) shouldParse(line *string, server string) bool {
 		return h.Sum64()
 	}(*line)
 
-	// Check memcached to see if it exist
-	mc := memcache.New(MC_HOST + ":" + MC_PORT)
+	window := DedupWindowFor(server)
+	seen := timestamp.Unix()
+	bucket := seen / window
+	key := func(bucket int64) string {
+		return server + ":" + fmt.Sprint(hash) + ":" + fmt.Sprint(bucket)
+	}
 
-	// Use an _ as we don't need to use the cache item returned
-	key := (server + ":" + fmt.Sprint(hash))
-	_, err := mc.Get(key)
+	// Claim the bucket with an add-if-absent so when several clients upload the
+	// same line at once exactly one of them gets to parse it.  A cache error
+	// parses the line rather than dropping it, the same as
+	// itemRecentlyAuctionedByPlayer, a duplicate row is better than a lost one
+	claimed := time.Now().UnixNano()
+	added, err := Dedup.Add(key(bucket), []byte(fmt.Sprint(seen) + ":" + fmt.Sprint(claimed)), time.Second * DEDUP_LINE_RETENTION_IN_SECS)
 	if err != nil {
-		if err.Error() == "memcache: cache miss" {
-			fmt.Println("Setting hash: " + fmt.Sprint(hash) + " in cache for: " + fmt.Sprint(CACHE_TIME_IN_SECS) + " seconds")
-			mc.Set(&memcache.Item{Key: key, Value: []byte(*line), Expiration: CACHE_TIME_IN_SECS})
-			return true
-		} else {
-			fmt.Println("Error was: ", err.Error())
+		fmt.Println("Error was: ", err.Error())
+		return true
+	}
+	if !added {
+		LogInDebugMode("Line already claimed: ", key(bucket))
+		return false
+	}
+
+	for _, neighbour := range []int64{bucket - 1, bucket + 1} {
+		value, found, err := Dedup.Get(key(neighbour))
+		if err != nil || !found {
+			continue
+		}
+		otherSeen, otherClaimed, ok := parseDedupClaim(value)
+		if !ok || otherSeen - seen > window || seen - otherSeen > window {
+			continue
+		}
+		// The earlier claim wins, a tie goes to the earlier bucket
+		if otherClaimed < claimed || (otherClaimed == claimed && neighbour < bucket) {
+			LogInDebugMode("Line was seen in the neighbouring window: ", key(neighbour))
 			return false
 		}
 	}
 
-	// If we got here then we couldn't reach memcached, or there was a value
-	// returned from memcached in which case we don't want to parse
-	fmt.Println("Key already exists: ", key)
-	return false
+	LogInDebugMode("Claimed line: " + key(bucket) + " for: " + fmt.Sprint(DEDUP_LINE_RETENTION_IN_SECS) + " seconds")
+	return true
+}
+
+// Reads the "seen:claimed" value shouldParse stores for a bucket, a value
+// without the claim time was stored before claims were ordered and wins
+func parseDedupClaim(value []byte) (int64, int64, bool) {
+	parts := strings.SplitN(string(value), ":", 2)
+	seen, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if len(parts) == 1 {
+		return seen, 0, true
+	}
+	claimed, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return seen, claimed, true
+}
+
+// Returns the dedup window for the server in seconds
+func DedupWindowFor(server string) int64 {
+	if window, ok := DEDUP_WINDOW_IN_SECS[strings.ToUpper(server)]; ok && window > 0 {
+		return int64(window)
+	}
+
+	return DEDUP_DEFAULT_WINDOW_IN_SECS
+}
+
+// Counts a unique sighting of a seller's auction towards their activity for
+// the hour, repeats of the same auction later on count again but the same
+// sighting uploaded by several clients only counts once
+func (c *AuctionController) recordActivity(auction *Auction) {
+	query := "INSERT INTO auction_activity (server, seller, hour, adverts) VALUES (?, ?, ?, 1) " +
+		"ON DUPLICATE KEY UPDATE adverts = adverts + 1"
+	DB.Exec(query, auction.Server, auction.Seller, auction.Timestamp.Truncate(time.Hour).Format("2006-01-02 15:04:05"))
+}
+
+// Counts a price check towards the demand for each item it names, by hour
+func (c *AuctionController) recordPriceChecks(auction *Auction) {
+	query := "INSERT INTO price_checks (server, item_id, hour, checks) " +
+		"SELECT ?, id, ?, 1 FROM items WHERE displayName = ? " +
+		"ON DUPLICATE KEY UPDATE checks = checks + 1"
+	hour := auction.Timestamp.Truncate(time.Hour).Format("2006-01-02 15:04:05")
+	for _, item := range auction.Items {
+		LogInDebugMode("Price check for: ", item.Name)
+		DB.Exec(query, auction.Server, hour, strings.TrimSpace(item.Name))
+	}
 }
 
 // If we should parse this line, we send a list of items to the Wiki Service
 // and then save unique auction data to the DB here (we do an initial save
 // of the items name and display name here but don't process stats from the wiki)
+// This runs on one of the pool workers so lines are parsed in turn rather
+// than spawning a goroutine for each of them
 func (c *AuctionController) parse(rawAuctions *RawAuctions, characterName, serverType string) {
 	var auctions []Auction
-	var outerWait sync.WaitGroup
-
-	c.ItemTrie = trie.NewTrie()
-	c.ItemTrie.Add("selling")
-	c.ItemTrie.Add("buying")
-	c.ItemTrie.Add("wtb")
-	c.ItemTrie.Add("wts")
-	c.ItemTrie.Add("ea")
-	c.ItemTrie.Add("each")
-	c.ItemTrie.Add("per")
-
-	// Load all items into Trie structure
-	itemQuery := "SELECT displayName, id FROM items ORDER BY displayName ASC"
-	rows := DB.Query(itemQuery)
-	if rows != nil {
-		for rows.Next() {
-			var itemName string
-			var itemId int64
-
-			rows.Scan(&itemName, &itemId)
-			if itemId > 0 && itemName != "" {
-				c.ItemTrie.Add(strings.ToLower(itemName))
-			}
-		}
-	}
 
+	offset := rawAuctions.ClockOffset()
+	if offset != 0 {
+		LogInDebugMode("Correcting the log timestamps of " + characterName + " by: ", offset.String())
+	}
 	for _, line := range rawAuctions.Lines {
-		outerWait.Add(1)
-		go c.parseLine(line, characterName, serverType, &outerWait, &auctions)
+		c.parseLine(line, characterName, serverType, rawAuctions.Zone, offset, &auctions)
 	}
 
-	outerWait.Wait()
 	fmt.Println("Processed all lines")
 
+	// Save first so the published events carry the item ids
 	if len(auctions) > 0 {
 		c.saveAuctionData(auctions)
 	}
+	for _, auction := range auctions {
+		c.publishAuction(auction)
+	}
+}
+
+// The catalog lines are currently matched against, see catalog.go
+func (c *AuctionController) Catalog() *Catalog {
+	return c.catalog.get()
+}
+
+func (c *AuctionController) SetCatalog(catalog *Catalog) {
+	c.catalog.set(catalog)
+}
+
+// Rebuilds the catalog from the items, aliases and name rules in the DB, the
+// parses already running finish on the catalog they started with
+func (c *AuctionController) RefreshCatalog() {
+	c.SetCatalog(LoadCatalog())
+}
+
+// Parses a single line with a trace of every step and without saving,
+// deduplicating or publishing anything.  The line can be a full log line or
+// just the text inside the quotes
+func (c *AuctionController) Explain(line, server string) ParseTrace {
+	trace := ParseTrace{Input: line, Steps: []ParseStep{}, Items: []AuctionEventItem{}}
+	auction, err := c.readLine(line, server, ACTIVE_PARSER, &trace)
+	trace.Cleaned = auction.cleaned
+	if err != nil {
+		trace.Error = err.Error()
+		return trace
+	}
+
+	trace.Text = auction.itemLine
+	trace.Label = auction.label
+	for _, item := range auction.Items {
+		trace.Items = append(trace.Items, newAuctionEventItem(item))
+	}
+
+	return trace
+}
+
+// Classifies the line and extracts its items with the parser version given
+// whatever the label, without deduplicating, saving or publishing anything.
+// The line can be a full log line or just the text inside the quotes
+func (c *AuctionController) readLine(line, server, version string, trace *ParseTrace) (Auction, error) {
+	normalised := NormaliseLine(line)
+	line = normalised.Text
+	auction := Auction{Server: server, trace: trace, original: normalised.Original, cleaned: normalised.Fixes}
+	parser, err := LookupParser(version)
+	if err != nil {
+		return auction, err
+	}
+
+	if c.isAuctionLine(&line) {
+		if err := c.extractParserInformationFromLine(line, &auction); err != nil {
+			return auction, err
+		}
+	} else {
+		auction.itemLine = strings.TrimSpace(line)
+	}
+
+	auction.label = ClassifyLine(auction.itemLine)
+	parser(c, &auction)
+
+	return auction, nil
+}
+
+// Explains how the parser reads the line in the request body, see ParseTrace
+func (c *AuctionController) explain(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Line   string `json:"line"`
+		Server string `json:"server"`
+	}
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", 400)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if strings.TrimSpace(request.Line) == "" {
+		http.Error(w, "Please send the line to explain", 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Explain(request.Line, strings.ToUpper(request.Server)))
 }
 
 // Extract
 func (c *AuctionController) extractParserInformationFromLine(line string, auction *Auction) error {
-	fmt.Println("Attempting to match: ", line)
-	reg := regexp.MustCompile(`(?m)^\[(?P<Timestamp>[A-Za-z0-9: ]+)+] (?P<Seller>[A-Za-z]+) auction[s]?, '(?P<Items>.+)'$`)
-	matches := reg.FindStringSubmatch(line)
+	LogInDebugMode("Attempting to match: ", line)
+	matches := auctionPartsRegex.FindStringSubmatch(line)
 	if len(matches) == 0 {
 		return errors.New("No matches found for expression")
 	}
@@ -207,78 +375,66 @@ func (c *AuctionController) extractParserInformationFromLine(line string, auctio
 	}
 
 	auction.raw = line
-	//auction.Timestamp = matches[1]  only temp maybe
+	auction.Timestamp = t
 	auction.Seller = matches[2]
 	auction.itemLine = matches[3]
 
-	fmt.Println("Auction raw: " + auction.raw);
-	fmt.Println("Auction seller: " + auction.Seller);
-	fmt.Println("Auction line: " + auction.itemLine);
+	LogInDebugMode("Auction raw: " + auction.raw);
+	LogInDebugMode("Auction seller: " + auction.Seller);
+	LogInDebugMode("Auction line: " + auction.itemLine);
 
 	return nil
 }
 
-// Pretty simple method, we query the trie in O(W * L) time to check
-// if it the item can be appended, if not we check if it has a spell
-// prefix and if it does we insert it to the trie, we insert with
-// a base value of quant 1.0 then our pricing parser will fill in the
-// rest
-// TODO this could be optimised, we do some many checks here where we
-// could probably optimised
-func (c *AuctionController) appendIfInTrie(item *Item, out *[]Item) bool {
-	if c.ItemTrie.Has(strings.TrimSpace(item.Name)) {
-		item.Quantity = 1.0
-		*out = append(*out, *item)
-		return true
-	} else if c.ItemTrie.Has("spell: " + strings.TrimSpace(item.Name))  {
-		item.Name = "spell: " + item.Name
-		item.Quantity = 1.0
-		*out = append(*out, *item)
-		return true
-	} else if c.ItemTrie.Has("rune of " + strings.TrimSpace(item.Name)) {
-		item.Name = "rune of " + item.Name
-		item.Quantity = 1.0
-		*out = append(*out, *item)
-		return true
-	} else if c.ItemTrie.Has("rune of the " + strings.TrimSpace(item.Name)) {
-		item.Name = "rune of the " + item.Name
-		item.Quantity = 1.0
-		*out = append(*out, *item)
-		return true
-	} else if c.ItemTrie.Has("words of " + strings.TrimSpace(item.Name)) {
-		item.Name = "words of " + item.Name
-		item.Quantity = 1.0
-		*out = append(*out, *item)
-		return true
-	} else if c.ItemTrie.Has("words of the " + strings.TrimSpace(item.Name)) {
-		item.Name = "words of the " + item.Name
-		item.Quantity = 1.0
-		*out = append(*out, *item)
-		return true
+// Registers the item the name just read stands for, the catalog state already
+// knows which item an alias or a bare name (e.g. "yaulp" for "spell: yaulp")
+// resolves to and we insert it under that name.  We insert with a base value
+// of quant 1.0 (or the quantity written in front of the item, e.g.
+// "10 Bone Chips") then our pricing parser will fill in the rest
+func (c *AuctionController) appendIfInCatalog(catalog *Catalog, state int, matched, intent string, auction *Auction) bool {
+	name := catalog.Name(state)
+	if name == "" {
+		auction.trace.Add(TRACE_NO_ITEM, []byte(matched), "")
+		return false
 	}
 
-	return false
+	item := Item{Name: matched, matched: matched, intent: intent, PriceBasis: PRICE_BASIS_LOT}
+	if name != matched {
+		item.Name = name
+	}
+	item.Quantity = auction.takePendingQuantity()
+	auction.Items = append(auction.Items, item)
+	auction.trace.Add(TRACE_ITEM, []byte(matched), item.Name + " x" + fmt.Sprint(item.Quantity))
+	return true
 }
 
 // New parse line strategy, code is fairly self explanatory
-func (c *AuctionController) parseLine(line, characterName, serverType string, wg *sync.WaitGroup, auctions *[]Auction) {
-	fmt.Println(c.ItemTrie.Has("wurmslayer"))
-	if !c.isAuctionLine(&line) {
-		wg.Done()
-	} else {
+func (c *AuctionController) parseLine(line, characterName, serverType, zone string, offset time.Duration, auctions *[]Auction) {
+	// Line endings, stray encodings and the like are cleaned up before the line
+	// is matched, see normalise.go.  The bytes the client sent are recorded
+	// first, so lines the cleanup stopped matching are kept as well
+	normalised := NormaliseLine(line)
+	line = normalised.Text
+	RecordNormalisedLine(serverType, normalised)
+
+	if c.isAuctionLine(&line) {
 		auction := Auction{}
-		item := Item{}
 
 		auction.Server = serverType
+		auction.Zone = zone
+		auction.original = normalised.Original
+		auction.cleaned = normalised.Fixes
 
 		err := c.extractParserInformationFromLine(line, &auction)
 		if err != nil {
-			fmt.Println(err.Error())
-			wg.Done()
+			fmt.Println(err.Error() + ", line as uploaded: " + fmt.Sprintf("%q", normalised.Original))
 			return
 		} else {
-			fmt.Println("Handling auction for seller: " + auction.Seller)
+			LogInDebugMode("Handling auction for seller: " + auction.Seller)
 		}
+		// Moves the client's wall clock onto ours so clients in other
+		// timezones dedup against each other, see RawAuctions.ClockOffset
+		auction.Timestamp = auction.Timestamp.Add(offset)
 
 		// check if we need to set the sellers name to the streaming clients name
 		// this happens when the log detects a you auction: line.  We want
@@ -290,8 +446,8 @@ func (c *AuctionController) parseLine(line, characterName, serverType string, wg
 
 		LogInDebugMode("Parsing line: ", line)
 
-		cachedLine := auction.Seller + " auctions, '" + auction.itemLine + "'"
-		if !c.shouldParse(&cachedLine, auction.Server) {
+		cachedLine := auction.Line()
+		if !c.shouldParse(&cachedLine, auction.Server, auction.Timestamp) {
 			// If we can't parse then just append it to the relay server (could be the same  message)
 			// dont do this yet, there is probably a better way of handling this!
 			fmt.Println("Can't parse this line: ", cachedLine)
@@ -303,182 +459,24 @@ func (c *AuctionController) parseLine(line, characterName, serverType string, wg
 			auctions = append(auctions, auction)
 			go c.publish(auctions, false)
 			*/
-			wg.Done()
 		} else {
-			//- Go one character at a time.
-			//- Start in WTS mode (since some people just say /auc Ale)
-			//- If we see WTB or "Buying" then switch to buying mode
-			//- If we see WTS or "Selling" then switch to selling mode
-			//- After consuming each character, check the items trie-test to see if anything
-			//matches
-			//- If the current characters aren't a prefix for anything, then throw away
-			//the current characters and start processing again.
-			//- If the current characters are a full match for an item, then register that
-			//item.
-			//- After finding an item, try to process the next characters as a price.
-			//TODO: it's hard to reason about the matching strategy.  Make it simpler.
-			//TODO: this doesn't support quantities like 'WTS Diamond x8 100pp each' or
-			//'WTS Diamond (8) 8k'.  A quantity without an 'x' will be interpreted
-			//as a price.
-			//TODO: this does greedy matches, which means that it'll think things like
-			//Yaulp IV are just plain old Yaulp.
-
-			fmt.Println("Parsing line: ", line)
-
-			buffer := []byte{}
-			selling := true
-			skippedChar := []byte{} // use an array so we can check the size
-
-			// Don't deal with capitlization, remove it here (trie only checks lowercase)
-			line = strings.ToLower(auction.itemLine)
-			line = ReplaceMultiple(line, " ", ",", "&", "\\", "/")
-
-			// NOTE: We use Go's `continue` kewyword to break execution flow instead of
-			// chaining else-if's.  I personally find this more readable with the
-			// comment blocks above each part of the parser!!
-			var prevMatch string = ""
-			for i, char := range strings.ToLower(line) {
-				buffer = append(buffer, byte(char))
-
-				// check for selling
-				if stringutil.CaseInsenstiveContains(string(buffer), "wts", "selling") {
-					selling = true
-					buffer = []byte{}
-					prevMatch = ""
-					skippedChar = []byte{}
-					continue
-				}
-
-				// check for buying
-				if stringutil.CaseInsenstiveContains(string(buffer), "wtb", "buying", "trading") {
-					selling = false
-					buffer = []byte{}
-					prevMatch = ""
-					skippedChar = []byte{}
-					continue
-				}
-
-				// check if we skipped a letter on the previous iteration and shift the items forward
-				// this checks example: wurmslayerale it would fail at wurmslayera we set "a" as the
-				// skipped character, extract wurmslayer and the begin to match ale using the "a" char
-				// once we append to the buffer we reset the skipped char to avoid prepending on
-				// subsequent calls
-				if len(skippedChar) > 0 {
-					buffer = append(skippedChar[0:1], buffer...)
-					skippedChar = []byte{}
-				}
-
-				// Create a test string based on the current buffer but we stripped the prefix of
-				// a or an from the front if we can't get a match on the initial buffer
-				// This will allow us to still match things like A Shamanistic Shenannigan Doll
-				nameWithoutPrefix := strings.ToLower(string(buffer))
-				nameWithoutPrefix = strings.Replace(strings.TrimSpace(nameWithoutPrefix), "a ", "", -1);
-				nameWithoutPrefix = strings.Replace(strings.TrimSpace(nameWithoutPrefix), "an ", "", -1);
-
-				if !c.ItemTrie.HasPrefix(strings.TrimLeft(string(buffer), " ")) && c.ItemTrie.HasPrefix(strings.TrimLeft(nameWithoutPrefix, " ")) {
-					buffer = []byte(nameWithoutPrefix)
-				}
-
-				if c.checkIfQuantityWasBasedOnEach(strings.TrimSpace(string(buffer))) && len(auction.Items) > 0 {
-					auction.Items[len(auction.Items) -1].Price *= float32(auction.Items[len(auction.Items) -1].Quantity)
-					buffer = []byte{}
-					prevMatch = ""
-					skippedChar = []byte{}
-					continue
-				}
-
-				// check if the current string exists in the buffer, we trim any spaces
-				// from the left but not the right as that can skew the results
-				// if we find a match store the previous match, for the next iteration.
-				// finally we check to see if we're at the last position in the line,
-				// if we are then we reset the buffer and attempt to append to the trie
-				// if our buffer contains a match
-				//fmt.Println("checking if trie has: ", string(buffer))
-				// TODO optimise the check for spell, rune, words etc. The method chaining
-				// could probably be done with a single lookup method instead of chaining in the condition
-				//
-				// If we don't find any matches in the trie then we want to clear out the buffer
-				// if the last character in the buffer is a space.  We do this because we still
-				// want to try and parse price data which is not stored in the tree obviously.
-				// If we don't clear the buffer then the parse can occasionally miss items
-				// on its pass through
-				if c.ItemTrie.HasPrefix(strings.TrimLeft(string(buffer), " ")) ||
-				   c.ItemTrie.HasPrefix("spell: " + strings.TrimLeft(string(buffer), " ")) ||
-				   c.ItemTrie.HasPrefix("words of " + strings.TrimLeft(string(buffer), " ")) ||
-				   c.ItemTrie.HasPrefix("words of the " + strings.TrimLeft(string(buffer), " ")) ||
-				   c.ItemTrie.HasPrefix("rune of " + strings.TrimLeft(string(buffer), " ")) ||
-				   c.ItemTrie.HasPrefix("rune of the " + strings.TrimLeft(string(buffer), " ")) {
-					prevMatch = string(buffer)
-					//fmt.Println("Has prefix: ", string(buffer))
-					if i == len(line)-1 {
-						buffer = []byte{}
-						item.Name = prevMatch
-						item.selling = selling
-						c.appendIfInTrie(&item, &auction.Items)
-						prevMatch = ""
-						skippedChar = []byte{}
-					}
-
-					continue
-				} else if(string(buffer[len(buffer)-1]) == " ") {
-					buffer = []byte{}
-				}
-				// The trie did not have the prefix composed of the char buffer, we now evaluate
-				// the "previousMatch" which is the buffer string n-1.  We can assume that
-				// on this iteration the new character accessed caused the buffer to be
-				// invalidated on the item trie, therefore we append this character
-				// into the skippedChar byte and then clear the buffer.
-				// On our next iteration we populate the buffer with this "skipped"
-				// character in order to build the next item line...
-				// this allows us to catch cases where items are budged
-				// up against one another without separators such as
-				// wurmslayerswiftwindale would allow us to extract:
-				// wurmslayer swiftwind ale
-				// NOTE: We don't reset the buffer in this method as we always want
-				// to check for Pricing and Quantity data, we will only reset
-				// the buffer if no match is found for meta information about
-				// the current item!
-				if prevMatch != "" {
-					//fmt.Println("Prev was: ", prevMatch)
-
-					// We don't want to put spaces back into the buffer, the whole purpose of
-					// skippedChar is to catch cases where uses budge items together.
-					// Therefore we will only append non space characters.
-					if string(byte(char)) != " " { skippedChar = append(skippedChar, byte(char)) }
-					//fmt.Println("Buffer is: ", (string(buffer)))
-					//fmt.Println("Skipped buffer is: ", string(skippedChar))
-
-					item.Name = prevMatch
-					item.selling = selling
-
-					c.appendIfInTrie(&item, &auction.Items)
-				}
-				// This is the final part of the parser, the previous block will have added a
-				// new item to the auction items array if it found a match in the trie, otherwise
-				// the array will remain the same.
-				// At this point we want to extract any meta information for this item.  We can
-				// assume that the buffer now contains information like " x2 50p" which we want
-				// to extract and assign to the item.   If however none of our price extraction
-				// reg-exs find a match we set "prevMatch" back to null and we also empty our buffer
-				// as we have now essentially exhausted our search for this item
-				if !item.ParsePriceAndQuantity(&buffer, &auction) {
-					prevMatch = ""
-					buffer = []byte{}
-
-					continue
-				}
-				// Just continue execution, nothing else to be caught here - this means that we have
-				// successfully extracted meta information, woot!
-				// NOTE: We reset the skippedChar buffer here as we found some meta information
-				// on the price or quantity, therefore we dont need to append on the next
-				// iteration of hte loop
-				skippedChar = []byte{}
+			// Price checks name the items people want, they are counted as demand rather
+			// than stored as listings.  Questions, LFG and spam are skipped
+			auction.label = ClassifyLine(auction.itemLine)
+			if auction.label == LINE_PRICE_CHECK {
+				c.parseItems(&auction)
+				c.recordPriceChecks(&auction)
+				return
+			} else if auction.label != LINE_LISTING {
+				LogInDebugMode("Skipping " + auction.label + " line: ", cachedLine)
+				return
 			}
 
-			//fmt.Println("Buffer is: ", string(buffer))
-			//fmt.Println("Is sell mode? ", selling)
-			//fmt.Println("Items is: ", &auction.Items)
-			//fmt.Println("Total items: ", fmt.Sprint(len(auction.Items)))
+			// Every unique sighting counts towards the seller's activity, even if
+			// the prices end up matching what we already stored
+			c.recordActivity(&auction)
+			c.parseItems(&auction)
+			RecordUnmatchedFragments(&auction)
 
 			itemsForWikiService := []string{}
 			for _, item := range auction.Items {
@@ -489,99 +487,313 @@ func (c *AuctionController) parseLine(line, characterName, serverType string, wg
 			}
 			//fmt.Println("Sending: " + fmt.Sprint(itemsForWikiService) + " to service")
 
-			// Append to the output array and send it to the web front end (batching updates looks slow)
+			// Append to the output array, it is published to the web front end once the upload is saved
 			*auctions = append(*auctions, auction)
-			go c.publishToRelayService(auction)
-			go c.sendItemsToWikiService(itemsForWikiService)
-
-			wg.Done()
+			c.sendItemsToWikiService(itemsForWikiService)
 		}
 	}
 }
 
-func (c *AuctionController) checkIfQuantityWasBasedOnEach(term string) bool {
-	fmt.Println("Checking line: ", term)
-	re := regexp.MustCompile(`[a-z]+`)
-	match := re.FindStringSubmatch(term)
-	if len(match) > 0 {
-		term = match[0]
+// Extracts the items with the active parser, the shadow parser (if there is
+// one) reads the line as well but only its differences are kept, see shadow.go
+func (c *AuctionController) parseItems(auction *Auction) {
+	PARSERS[ACTIVE_PARSER](c, auction)
+	if Shadow.Enabled() {
+		Shadow.Compare(c, auction)
 	}
+}
 
-	fmt.Println("Each is: ", term)
-	return term == "ea" || term == "each" || term == "per"
+// Reads the line in a single pass, one transition of the catalog automaton per
+// character, pulling out every item along with its price, quantity and
+// intent, this is parser version "1", see parsers.go
+func (c *AuctionController) extractItems(auction *Auction) {
+	//- Go one character at a time.
+	//- Start in WTS mode (since some people just say /auc Ale)
+	//- While the text read since the last item is the start of something in
+	//the catalog (an item, alias or keyword) follow it through the automaton.
+	//- A keyword which changes the intent ("wtb", "selling", "trade for"...)
+	//takes effect as soon as it is read.
+	//- When the next character can't carry the name on it is finished: an item
+	//is registered, or "ea"/"each"/"per" and "obo"/"firm"... are applied to the
+	//last item's price if the word ended there.  The failure link gives the
+	//tail of the text which could start the next name, so items budged up
+	//against each other ("wurmslayerswiftwindale") and articles ("a Flowing
+	//Thought") are read without going back over the line.
+	//- Text which can't start a name is read as the last item's price or
+	//quantity, until a space or until it can't be one either.
+	//TODO: this doesn't support quantities like 'WTS Diamond (8) 8k'.  A
+	//quantity without an 'x' will be interpreted as a price.
+	//Run the golden corpus (./service-collection corpus) after changing any of
+	//this, the cases above are kept there as pending entries.
+
+	LogInDebugMode("Parsing line: ", auction.itemLine)
+
+	catalog := c.Catalog()
+	intent := INTENT_SELL
+
+	// Don't deal with capitlization, remove it here (the catalog only holds lowercase)
+	line := strings.ToLower(auction.itemLine)
+	line = NormaliseCharges(line, catalog)
+	// Before the separators become spaces, see JoinSpacedDenominations
+	line = JoinSpacedDenominations(line)
+	line = ReplaceMultiple(line, " ", ",", "&", "\\", "/")
+	line = JoinPriceRanges(line)
+	if auction.trace != nil {
+		auction.trace.Normalised = line
+	}
+
+	state := CATALOG_DEAD // the name being read, CATALOG_DEAD while we aren't reading one
+	named := 0            // the name's state at its last character which wasn't a space
+	buffer := []byte{}    // the text of the name, or of the price, being read
+	item := Item{}
+
+	// Follows the name into the state, a keyword which changes the intent
+	// ends it straight away
+	follow := func(next int) {
+		state = next
+		if buffer[len(buffer)-1] != ' ' {
+			named = next
+		}
+		if switched, ok := intentWords[catalog.Keyword(next)]; ok {
+			intent = switched
+			auction.trace.Add(TRACE_INTENT, buffer, intent)
+			state = CATALOG_DEAD
+			buffer = buffer[:0]
+			return
+		}
+		auction.trace.Add(TRACE_PREFIX_HIT, buffer, "")
+	}
+
+	// The name ended before the character (or the end of the line), returns
+	// whether it was an item or a keyword we acted on
+	finishName := func(wordEnded bool) bool {
+		keyword := catalog.Keyword(named)
+		if wordEnded && len(auction.Items) > 0 {
+			last := &auction.Items[len(auction.Items) -1]
+			if unitWords[keyword] {
+				last.PriceBasis = PRICE_BASIS_UNIT
+				auction.trace.Add(TRACE_UNIT_PRICE, buffer, "")
+				return true
+			} else if flag := NEGOTIATION_WORDS[keyword]; flag != "" {
+				last.AddNegotiationFlag(flag)
+				auction.trace.Add(TRACE_NEGOTIATION, buffer, flag)
+				return true
+			}
+		}
+
+		return c.appendIfInCatalog(catalog, named, string(buffer[:catalog.Depth(named)]), intent, auction)
+	}
+
+	// Reads the buffer as a price or quantity for the last item, the buffer is
+	// kept while it still could be one.  A single "x" or digit is kept as
+	// well, the price may only be readable once it is complete ("x10")
+	readPrice := func() {
+		if !item.ParsePriceAndQuantity(&buffer, auction) {
+			if len(buffer) == 1 && strings.IndexByte("0123456789.x#", buffer[0]) >= 0 {
+				return
+			}
+			auction.trace.Add(TRACE_BUFFER_RESET, buffer, "no price or quantity")
+			buffer = buffer[:0]
+		}
+	}
+
+	// Names start at the beginning of a word, so "ale" isn't read out of "scale"
+	wordStart := func(i int) bool {
+		return i == 0 || !isLowerLetter(rune(line[i-1]))
+	}
+
+	for i := 0; i < len(line); i++ {
+		char := line[i]
+		auction.trace.At(i, rune(char))
+
+		if state != CATALOG_DEAD {
+			buffer = append(buffer, char)
+			if next := catalog.Step(state, char); next != CATALOG_DEAD {
+				follow(next)
+				continue
+			}
+
+			// The name can't go on, act on what it was
+			auction.trace.Add(TRACE_PREFIX_MISS, buffer, "")
+			buffer = buffer[:len(buffer)-1]
+			used := finishName(!isLowerLetter(rune(char)))
+			if char == ' ' {
+				auction.trace.Add(TRACE_BUFFER_RESET, buffer, "space")
+				state = CATALOG_DEAD
+				buffer = buffer[:0]
+				continue
+			}
+
+			// The longest tail of the name and this character which could start
+			// another name carries on from there, straight after an item
+			// ("wurmslayerswiftwind") or at the start of a word
+			if tail := catalog.Next(state, char); tail != 0 && (used || wordStart(i + 1 - catalog.Depth(tail))) {
+				buffer = append(buffer, char)
+				buffer = append(buffer[:0], buffer[len(buffer) - catalog.Depth(tail):]...)
+				auction.trace.Add(TRACE_SKIPPED_CHAR, buffer, "held " + string(buffer))
+				named = 0
+				follow(tail)
+				continue
+			}
+
+			// Otherwise this character starts a price ("Ale5p") or, if the name
+			// wasn't anything, the whole text might be one ("x10")
+			state = CATALOG_DEAD
+			if used {
+				buffer = buffer[:0]
+			}
+			buffer = append(buffer, char)
+			if len(buffer) > 1 && !item.ParsePriceAndQuantity(&buffer, auction) {
+				buffer = append(buffer[:0], char)
+			}
+			readPrice()
+			continue
+		}
+
+		if char == ' ' {
+			if len(buffer) > 0 {
+				auction.trace.Add(TRACE_BUFFER_RESET, buffer, "space")
+				buffer = buffer[:0]
+			}
+			continue
+		}
+
+		if len(buffer) == 0 && wordStart(i) {
+			if next := catalog.Step(0, char); next != CATALOG_DEAD {
+				buffer = append(buffer, char)
+				named = 0
+				follow(next)
+				continue
+			}
+		}
+
+		buffer = append(buffer, char)
+		readPrice()
+	}
+
+	if state != CATALOG_DEAD {
+		finishName(true)
+	}
+
+	// Now we know every item's quantity work out its unit and lot prices
+	for i := range auction.Items {
+		auction.Items[i].ResolvePrices()
+	}
+}
+
+func isLowerLetter(r rune) bool {
+	return r >= 'a' && r <= 'z'
 }
 
-// Publishes a list of items to the wiki service to fetch their stats
+// Queues a list of items for the wiki service to fetch their stats, the
+// outbox dispatcher takes care of delivering (and retrying) the request
 func (c *AuctionController) sendItemsToWikiService(items []string) {
 	if len(items) > 0 {
-		//fmt.Println("Sending: " + fmt.Sprint(len(items)) + " items to wiki service.")
-
 		encodedItems, _ := json.Marshal(items)
-		resp, err := http.Post("http://" + WIKI_SERVICE_HOST + ":" + WIKI_SERVICE_PORT + "/items", "application/json", bytes.NewBuffer(encodedItems))
-		if err != nil {
-			fmt.Println(err)
-		} else {
-			fmt.Println("Response from wiki service: ", resp.StatusCode)
-		}
+		Deliveries.Enqueue(DESTINATION_WIKI, "", encodedItems)
+	}
+}
+
+// Delivers a list of item names queued by sendItemsToWikiService
+func (c *AuctionController) deliverToWikiService(topic string, payload []byte) error {
+	var client = &http.Client {
+		Timeout: time.Second * 10,
 	}
+	resp, err := client.Post("http://" + WIKI_SERVICE_HOST + ":" + WIKI_SERVICE_PORT + "/items", "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	LogInDebugMode("Response from wiki service: ", resp.StatusCode)
+	if resp.StatusCode >= 300 {
+		return errors.New("wiki service responded with: " + resp.Status)
+	}
+
+	return nil
 }
 
-// Publishes new auction data to Amazon SQS, this service is responsible
-// for being the publisher in the pub/sub model, the Relay server
-// is the subscriber which streams the data to the consumer via socket.io
+// Saves the unique auctions from an upload to the auctions table
 func (c *AuctionController) saveAuctionData(auctions []Auction) {
 	// Spawn all go save events:
 	//fmt.Println("Saving: " + fmt.Sprint(len(auctions)) + " auctions", auctions)
 	// Removed timestamp temporarily, mayb eperm
-	auctionQuery := "INSERT INTO auctions (player_id, item_id, price, quantity, server, raw_auction, for_sale) " +
-		" VALUES "
+	auctionQuery := "INSERT INTO auctions (" + AUCTION_COLUMNS + ") VALUES "
 
-	wg := sync.WaitGroup{}
 	var auctionParams []interface{}
-	for _, auction := range auctions {
-		wg.Add(1)
-		a := auction
-		go a.ExtractQueryInformation(func(values string, parameters []interface{}) {
+	rows := 0
+	for i := range auctions {
+		// Take a pointer so the item ids looked up here are kept on the auction
+		a := &auctions[i]
+		a.ExtractQueryInformation(func(values string, parameters []interface{}) {
 			if parameters != nil && values != "" {
 				auctionParams = append(auctionParams, parameters...)
 				auctionQuery += values
+				rows += strings.Count(values, AUCTION_ROW)
 			}
-			wg.Done()
 		})
 	}
 
-	wg.Wait()
-
 	auctionQuery = auctionQuery[0:len(auctionQuery)-1]
-	fmt.Println("Params are: ", auctionParams)
-	fmt.Println("Query is: ", auctionQuery)
+	LogInDebugMode("Params are: ", auctionParams...)
+	LogInDebugMode("Query is: ", auctionQuery)
 	if DB.conn != nil && len(auctionParams) > 0 {
 		DB.Insert(auctionQuery, auctionParams...)
 	}
 
-	fmt.Println("Successfully saved: " + fmt.Sprint(len(auctionParams) / 5) + " items for auction")
+	fmt.Println("Successfully saved: " + fmt.Sprint(rows) + " items for auction")
+
+	c.saveListingChanges(auctions)
+	for i := range auctions {
+		TrackListings(&auctions[i])
+	}
 }
 
-func (c *AuctionController) publishToRelayService(auction Auction) {
-	// Push to our Websocket server
-	fmt.Println("Pushing: " + fmt.Sprint(len(auction.Items)) + " items in this auction to relay server.")
+// Saves the price, quantity, charges and intent changes found while saving the
+// auctions so we have a history of each listing
+func (c *AuctionController) saveListingChanges(auctions []Auction) {
+	changeQuery := "INSERT INTO listing_changes (player_id, item_id, server, old_price, new_price, old_quantity, new_quantity, old_charges, new_charges, old_intent, new_intent, changed_at) VALUES "
 
-	// Serialize to JSON to pass to the Relay server
-	sa := SerializedAuction{AuctionLine: auction}
-	req, err := http.NewRequest("POST", "http://" + RELAY_SERVICE_HOST + ":" + RELAY_SERVICE_PORT + "/auctions/" + strings.ToLower(auction.Server), bytes.NewBuffer(sa.toJSONString()))
-	if err != nil {
-		return
+	// Charges are NULL when the seller didn't give any, the same as auctions
+	chargesOrNull := func(charges int16) interface{} {
+		if charges > 0 {
+			return charges
+		}
+		return nil
 	}
-	req.Header.Set("Content-Type", "application/json")
-	//fmt.Print("Sending req: ", req)
 
-	var client = &http.Client {
-		Timeout: time.Second * 10,
+	var changeParams []interface{}
+	changes := 0
+	for _, auction := range auctions {
+		for _, change := range auction.Changes {
+			changeQuery += "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?),"
+			changeParams = append(changeParams, change.playerId, change.ItemId, change.Server,
+				change.Old.Price, change.New.Price, change.Old.Quantity, change.New.Quantity,
+				chargesOrNull(change.Old.Charges), chargesOrNull(change.New.Charges),
+				change.Old.Intent, change.New.Intent, change.Timestamp.Format("2006-01-02 15:04:05"))
+			changes++
+		}
 	}
-	resp, err := client.Do(req)
-	if err != nil {
 
-	} else {
-		defer resp.Body.Close()
+	if DB.conn != nil && len(changeParams) > 0 {
+		changeQuery = changeQuery[0:len(changeQuery)-1]
+		DB.Insert(changeQuery, changeParams...)
+		fmt.Println("Saved: " + fmt.Sprint(changes) + " listing changes")
+	}
+}
+
+// Queues the auction for every configured publisher (the relay server which
+// streams it to the web front end, SQS, NATS), each publisher gets the topic
+// and schema version configured for it
+func (c *AuctionController) publishAuction(auction Auction) {
+	fmt.Println("Queueing: " + fmt.Sprint(len(auction.Items)) + " items in this auction for " + fmt.Sprint(len(Publishers)) + " publishers.")
+
+	for _, p := range Publishers {
+		payload, err := EncodeAuction(auction, SchemaVersionFor(p.Name()))
+		if err != nil {
+			fmt.Println("Can't publish auction to " + p.Name() + ": ", err)
+			continue
+		}
+		Deliveries.Enqueue(p.Name(), TopicFor(p, auction.Server), payload)
 	}
 }
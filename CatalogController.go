@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net/http"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"github.com/gorilla/mux"
+)
+
+// Lets a curator review the text players auction which didn't match an item
+// and add it to the catalog, see fragments.go
+type CatalogController struct {
+	Controller
+}
+
+type UnmatchedFragment struct {
+	Id         int64    `json:"id"`
+	Server     string   `json:"server"`
+	Fragment   string   `json:"fragment"`
+	Seen       int64    `json:"seen"`
+	FirstSeen  string   `json:"firstSeen"`
+	LastSeen   string   `json:"lastSeen"`
+	Status     string   `json:"status"`
+	Resolution string   `json:"resolution"`
+	Examples   []string `json:"examples"`
+}
+
+// Lists fragments most seen first, filtered by ?server= and ?status= (new by
+// default), ?limit= caps the number returned
+func (c *CatalogController) fragments(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = FRAGMENT_NEW
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	query := "SELECT id, server, fragment, seen, DATE_FORMAT(first_seen, '%Y-%m-%d %H:%i:%s'), DATE_FORMAT(last_seen, '%Y-%m-%d %H:%i:%s'), status, COALESCE(resolution, '') " +
+		"FROM unmatched_fragments WHERE status = ?"
+	params := []interface{}{status}
+	if server := strings.ToUpper(r.URL.Query().Get("server")); server != "" {
+		query += " AND server = ?"
+		params = append(params, server)
+	}
+	query += " ORDER BY seen DESC LIMIT ?"
+	params = append(params, limit)
+
+	fragments := []UnmatchedFragment{}
+	rows := DB.Query(query, params...)
+	if rows == nil {
+		http.Error(w, "Could not load the unmatched fragments", 500)
+		return
+	}
+	for rows.Next() {
+		var f UnmatchedFragment
+		if err := rows.Scan(&f.Id, &f.Server, &f.Fragment, &f.Seen, &f.FirstSeen, &f.LastSeen, &f.Status, &f.Resolution); err != nil {
+			fmt.Println("Scan error: ", err)
+			continue
+		}
+		fragments = append(fragments, f)
+	}
+	DB.CloseRows(rows)
+
+	for i := range fragments {
+		fragments[i].Examples = c.examples(fragments[i].Id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fragments)
+}
+
+func (c *CatalogController) examples(fragmentId int64) []string {
+	examples := []string{}
+	rows := DB.Query("SELECT line FROM unmatched_fragment_examples WHERE fragment_id = ? ORDER BY id ASC", fragmentId)
+	if rows == nil {
+		return examples
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err == nil {
+			examples = append(examples, line)
+		}
+	}
+
+	return examples
+}
+
+// Promotes a fragment, the body is either {"aliasOf": "Cloak of Flames"} to
+// match the fragment as an existing item from now on, or {"item": "Name"} to
+// have the wiki service look up and add a new item (the name defaults to the
+// fragment).  Promoting the same alias again succeeds, the alias is matched
+// once the catalog refresher rebuilds the catalog
+func (c *CatalogController) promoteFragment(w http.ResponseWriter, r *http.Request) {
+	fragment, ok := c.fragment(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Item    string `json:"item"`
+		AliasOf string `json:"aliasOf"`
+	}
+	// An empty body promotes the fragment as a new item of the same name
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body: " + err.Error(), 400)
+			return
+		}
+	}
+
+	var resolution string
+	if aliasOf := strings.TrimSpace(request.AliasOf); aliasOf != "" {
+		itemId, found := c.itemId(aliasOf)
+		if !found {
+			http.Error(w, "There is no item called: " + aliasOf, 404)
+			return
+		}
+
+		// An alias which already points at the item changes nothing, MySQL
+		// reports no affected rows for it but it isn't an error
+		query := "INSERT INTO item_aliases (alias, item_id) VALUES (?, ?) ON DUPLICATE KEY UPDATE item_id = VALUES(item_id)"
+		if _, err := DB.Exec(query, fragment.Fragment, itemId); err != nil {
+			http.Error(w, "Failed to add the alias: " + err.Error(), 500)
+			return
+		}
+		resolution = "alias of " + aliasOf
+
+		// Match the alias within a few seconds rather than at the next full
+		// refresh, without every promotion rebuilding the catalog itself
+		CatalogRefresh.MarkDirty()
+	} else {
+		name := strings.TrimSpace(request.Item)
+		if name == "" {
+			name = TitleCase(fragment.Fragment, false)
+		}
+		AC.sendItemsToWikiService([]string{name})
+		resolution = "item " + name
+	}
+
+	c.resolve(w, fragment, FRAGMENT_PROMOTED, resolution)
+}
+
+// Looks up the id of the item with the display name
+func (c *CatalogController) itemId(displayName string) (int64, bool) {
+	rows := DB.Query("SELECT id FROM items WHERE displayName = ? LIMIT 1", displayName)
+	if rows == nil {
+		return 0, false
+	}
+	defer DB.CloseRows(rows)
+
+	var id int64
+	if !rows.Next() || rows.Scan(&id) != nil {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// Marks a fragment as reviewed and not an item
+func (c *CatalogController) ignoreFragment(w http.ResponseWriter, r *http.Request) {
+	fragment, ok := c.fragment(w, r)
+	if !ok {
+		return
+	}
+
+	c.resolve(w, fragment, FRAGMENT_IGNORED, "")
+}
+
+// Loads the fragment named in the route, answering 404 if there isn't one
+func (c *CatalogController) fragment(w http.ResponseWriter, r *http.Request) (UnmatchedFragment, bool) {
+	var f UnmatchedFragment
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid fragment id", 400)
+		return f, false
+	}
+
+	rows := DB.Query("SELECT id, server, fragment, seen, status FROM unmatched_fragments WHERE id = ?", id)
+	if rows == nil {
+		http.Error(w, "Could not load the fragment", 500)
+		return f, false
+	}
+	defer DB.CloseRows(rows)
+
+	if !rows.Next() {
+		http.Error(w, "No fragment with id: " + fmt.Sprint(id), 404)
+		return f, false
+	}
+	if err := rows.Scan(&f.Id, &f.Server, &f.Fragment, &f.Seen, &f.Status); err != nil {
+		http.Error(w, "Could not load the fragment", 500)
+		return f, false
+	}
+
+	return f, true
+}
+
+func (c *CatalogController) resolve(w http.ResponseWriter, fragment UnmatchedFragment, status, resolution string) {
+	_, err := DB.Exec("UPDATE unmatched_fragments SET status = ?, resolution = NULLIF(?, '') WHERE id = ?", status, resolution, fragment.Id)
+	if err != nil {
+		http.Error(w, "Failed to update the fragment: " + err.Error(), 500)
+		return
+	}
+
+	fragment.Status = status
+	fragment.Resolution = resolution
+	fragment.Examples = c.examples(fragment.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fragment)
+}
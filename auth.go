@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Asks the gatekeeper service whether the api key and email pair is valid,
+// returns the gatekeeper's status code and response body (200 when valid)
+func Authenticate(apiKey, email string) (int, string) {
+	req, err := http.NewRequest("GET", "http://" + GATEKEEPER_SERVICE_HOST + ":" + GATEKEEPER_SERVICE_PORT + "/auth", nil)
+	if err != nil {
+		return 500, "Couldn't contact the gatekeeper service"
+	}
+	req.Header.Set("apiKey", apiKey)
+	req.Header.Set("email", email)
+
+	var client = &http.Client {
+		Timeout: time.Second * 10,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 500, "Could not reach the gatekeeper service"
+	}
+	defer resp.Body.Close()
+	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(bodyBytes)
+}
+
+// Wraps a handler which changes state (requeueing deliveries, curating the
+// catalog, reprocessing) so only the emails in ADMIN_EMAILS can call it, the
+// apiKey and email headers are checked with the gatekeeper the same as an upload
+func RequireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := strings.TrimSpace(r.Header.Get("apiKey"))
+		email := strings.TrimSpace(r.Header.Get("email"))
+
+		admin := false
+		for _, allowed := range ADMIN_EMAILS {
+			if email != "" && strings.EqualFold(email, allowed) {
+				admin = true
+			}
+		}
+		if apiKey == "" || !admin {
+			http.Error(w, "Please send the apiKey and email of an admin", 401)
+			return
+		}
+
+		if status, body := Authenticate(apiKey, email); status != 200 {
+			http.Error(w, body, status)
+			return
+		}
+
+		handler(w, r)
+	}
+}
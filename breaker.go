@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	BREAKER_CLOSED    = "closed"
+	BREAKER_OPEN      = "open"
+	BREAKER_HALF_OPEN = "half-open"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: CircuitBreaker
+ |--------------------------------------------------------------------------
+ |
+ | Stops us hammering a destination which is down.  After `threshold`
+ | consecutive failures the breaker opens and Allow returns false until
+ | `cooldown` has passed, at which point a single trial delivery is let
+ | through (half-open), if that succeeds the breaker closes again.
+ |
+ | @member threshold (int): Consecutive failures before the breaker opens
+ | @member cooldown (time.Duration): How long the breaker stays open
+ |
+ */
+
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{state: BREAKER_CLOSED, threshold: threshold, cooldown: cooldown}
+}
+
+// Returns true if a delivery may be attempted right now
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BREAKER_OPEN:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BREAKER_HALF_OPEN
+		return true
+	case BREAKER_HALF_OPEN:
+		// Only the one trial delivery is allowed until we hear back from it
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BREAKER_CLOSED
+	b.failures = 0
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BREAKER_HALF_OPEN || b.failures >= b.threshold {
+		b.state = BREAKER_OPEN
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// How long until an open breaker lets a trial delivery through
+func (b *CircuitBreaker) RetryIn() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BREAKER_OPEN {
+		return 0
+	}
+	remaining := b.cooldown - time.Since(b.openedAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: FallbackDedupCache
+ |--------------------------------------------------------------------------
+ |
+ | Wraps a shared dedup cache (memcache or redis) with a local in-memory
+ | LRU.  The first time the shared cache returns an error we switch to
+ | degraded mode: every call goes to the local cache, a warning is raised
+ | on GET /health and the shared cache is probed in the background.  Once
+ | a probe succeeds the keys written locally while degraded are copied up
+ | to the shared cache (with Add, so anything another replica wrote in the
+ | meantime wins) and we switch back.
+ |
+ | While degraded each replica only dedups against what it has seen itself,
+ | so some duplicate rows are possible, but no lines are dropped.
+ |
+ | Writes to the local cache hold the read lock from checking that we are
+ | degraded until the write is done, and switching back drains the local
+ | cache under the write lock, so no local write can land after the drain
+ | and be missed by the resync.
+ |
+ */
+
+type FallbackDedupCache struct {
+	primary   DedupCache
+	local     *MemoryDedupCache
+	mu        sync.RWMutex
+	degraded  bool
+	since     time.Time
+	lastError string
+}
+
+func NewFallbackDedupCache(primary DedupCache, local *MemoryDedupCache) *FallbackDedupCache {
+	return &FallbackDedupCache{primary: primary, local: local}
+}
+
+func (c *FallbackDedupCache) Name() string {
+	return c.primary.Name()
+}
+
+func (c *FallbackDedupCache) Get(key string) ([]byte, bool, error) {
+	if !c.isDegraded() {
+		value, found, err := c.primary.Get(key)
+		if err == nil {
+			return value, found, nil
+		}
+		c.degrade(err)
+	}
+
+	return c.local.Get(key)
+}
+
+func (c *FallbackDedupCache) Set(key string, value []byte, ttl time.Duration) error {
+	for {
+		var err error
+		if c.whileDegraded(func() { err = c.local.Set(key, value, ttl) }) {
+			return err
+		}
+		if err = c.primary.Set(key, value, ttl); err == nil {
+			return nil
+		}
+		c.degrade(err)
+	}
+}
+
+func (c *FallbackDedupCache) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	for {
+		var added bool
+		var err error
+		if c.whileDegraded(func() { added, err = c.local.Add(key, value, ttl) }) {
+			return added, err
+		}
+		if added, err = c.primary.Add(key, value, ttl); err == nil {
+			return added, nil
+		}
+		c.degrade(err)
+	}
+}
+
+func (c *FallbackDedupCache) GetForUpdate(key string) (*CacheEntry, error) {
+	if !c.isDegraded() {
+		entry, err := c.primary.GetForUpdate(key)
+		if err == nil {
+			return entry, nil
+		}
+		c.degrade(err)
+	}
+
+	return c.local.GetForUpdate(key)
+}
+
+// An entry read from one cache can't be swapped in the other, if we changed
+// mode in between the swap fails and the caller reads the value again
+func (c *FallbackDedupCache) CompareAndSwap(key string, entry *CacheEntry, value []byte, ttl time.Duration) (bool, error) {
+	for {
+		var swapped bool
+		var err error
+		if c.whileDegraded(func() { swapped, err = c.local.CompareAndSwap(key, entry, value, ttl) }) {
+			return swapped, err
+		}
+		if swapped, err = c.primary.CompareAndSwap(key, entry, value, ttl); err == nil {
+			return swapped, nil
+		}
+		c.degrade(err)
+	}
+}
+
+// Returns a warning for GET /health while we are running on the local cache
+func (c *FallbackDedupCache) Warnings() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.degraded {
+		return nil
+	}
+
+	return []string{"dedup cache " + c.primary.Name() + " unreachable since " + c.since.Format(time.RFC3339) +
+		", deduplicating locally: " + c.lastError}
+}
+
+func (c *FallbackDedupCache) isDegraded() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.degraded
+}
+
+// Runs the write against the local cache if we are degraded, holding the read
+// lock so the resync can't drain the local cache until it is done.  Returns
+// false without running it when the shared cache should be used
+func (c *FallbackDedupCache) whileDegraded(write func()) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.degraded {
+		return false
+	}
+	write()
+	return true
+}
+
+func (c *FallbackDedupCache) degrade(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastError = err.Error()
+	if c.degraded {
+		return
+	}
+
+	fmt.Println("WARNING: dedup cache " + c.primary.Name() + " failed, falling back to the local cache: ", err)
+	c.degraded = true
+	c.since = time.Now()
+	go c.probe()
+}
+
+// Probes the shared cache until it answers again, then resyncs
+func (c *FallbackDedupCache) probe() {
+	ticker := time.NewTicker(time.Second * DEDUP_PROBE_INTERVAL_IN_SECS)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := c.primary.Set("dedup:probe", []byte(fmt.Sprint(time.Now().Unix())), time.Minute)
+		if err != nil {
+			c.mu.Lock()
+			c.lastError = err.Error()
+			c.mu.Unlock()
+			continue
+		}
+
+		// Drain in the same critical section as switching back, local writes
+		// already under way finish first and none start after
+		c.mu.Lock()
+		c.degraded = false
+		entries := c.local.Drain()
+		c.mu.Unlock()
+
+		c.resync(entries)
+		return
+	}
+}
+
+// Copies the keys written locally while degraded up to the shared cache so
+// other replicas don't reprocess what we already handled
+func (c *FallbackDedupCache) resync(entries []memorySnapshot) {
+	synced := 0
+	for _, entry := range entries {
+		if _, err := c.primary.Add(entry.key, entry.value, entry.ttl); err != nil {
+			fmt.Println("Failed to resync dedup key " + entry.key + ": ", err)
+			continue
+		}
+		synced++
+	}
+
+	fmt.Println("Dedup cache " + c.primary.Name() + " is back, resynced " + fmt.Sprint(synced) + " of " + fmt.Sprint(len(entries)) + " local keys")
+}
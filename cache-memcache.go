@@ -0,0 +1,76 @@
+package main
+
+import (
+	"time"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// Dedup cache backed by memcached, the client keeps its own connection pool
+// so one instance is shared by every request
+type MemcacheDedupCache struct {
+	client *memcache.Client
+}
+
+func NewMemcacheDedupCache(server string) *MemcacheDedupCache {
+	return &MemcacheDedupCache{client: memcache.New(server)}
+}
+
+func (c *MemcacheDedupCache) Name() string {
+	return DEDUP_MEMCACHE
+}
+
+func (c *MemcacheDedupCache) Get(key string) ([]byte, bool, error) {
+	item, err := c.client.Get(key)
+	if err == memcache.ErrCacheMiss {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return item.Value, true, nil
+}
+
+func (c *MemcacheDedupCache) Set(key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(&memcache.Item{Key: key, Value: value, Expiration: int32(ttl / time.Second)})
+}
+
+func (c *MemcacheDedupCache) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	err := c.client.Add(&memcache.Item{Key: key, Value: value, Expiration: int32(ttl / time.Second)})
+	if err == memcache.ErrNotStored {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Returns nil on a miss, the memcache item (which carries the cas id) is the token
+func (c *MemcacheDedupCache) GetForUpdate(key string) (*CacheEntry, error) {
+	item, err := c.client.Get(key)
+	if err == memcache.ErrCacheMiss {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &CacheEntry{Value: item.Value, token: item}, nil
+}
+
+func (c *MemcacheDedupCache) CompareAndSwap(key string, entry *CacheEntry, value []byte, ttl time.Duration) (bool, error) {
+	item, ok := entry.token.(*memcache.Item)
+	if !ok {
+		return false, nil
+	}
+	item.Value = value
+	item.Expiration = int32(ttl / time.Second)
+
+	err := c.client.CompareAndSwap(item)
+	if err == memcache.ErrCASConflict || err == memcache.ErrNotStored || err == memcache.ErrCacheMiss {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
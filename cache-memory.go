@@ -0,0 +1,164 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// In-process dedup cache, an LRU capped at `size` entries where every entry
+// also expires after its TTL.  Nothing is shared between replicas so this is
+// only meant for tests and single node deployments
+type MemoryDedupCache struct {
+	mu      sync.Mutex
+	size    int
+	version uint64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	version   uint64
+	expiresAt time.Time
+}
+
+// A live entry copied out of the cache by Drain
+type memorySnapshot struct {
+	key   string
+	value []byte
+	ttl   time.Duration
+}
+
+func NewMemoryDedupCache(size int) *MemoryDedupCache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &MemoryDedupCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *MemoryDedupCache) Name() string {
+	return DEDUP_MEMORY
+}
+
+func (c *MemoryDedupCache) Get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.live(key)
+	if entry == nil {
+		return nil, false, nil
+	}
+
+	return entry.value, true, nil
+}
+
+func (c *MemoryDedupCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(key, value, ttl)
+	return nil
+}
+
+func (c *MemoryDedupCache) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.live(key) != nil {
+		return false, nil
+	}
+
+	c.store(key, value, ttl)
+	return true, nil
+}
+
+// Returns nil on a miss, the entry's version is the token
+func (c *MemoryDedupCache) GetForUpdate(key string) (*CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.live(key)
+	if entry == nil {
+		return nil, nil
+	}
+
+	return &CacheEntry{Value: entry.value, token: entry.version}, nil
+}
+
+func (c *MemoryDedupCache) CompareAndSwap(key string, expected *CacheEntry, value []byte, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.live(key)
+	if entry == nil || entry.version != expected.token {
+		return false, nil
+	}
+
+	c.store(key, value, ttl)
+	return true, nil
+}
+
+// Empties the cache and returns the entries which hadn't expired yet along
+// with the time they had left to live
+func (c *MemoryDedupCache) Drain() []memorySnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var snapshot []memorySnapshot
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*memoryEntry)
+		if ttl := entry.expiresAt.Sub(now); ttl > time.Second {
+			snapshot = append(snapshot, memorySnapshot{key: entry.key, value: entry.value, ttl: ttl})
+		}
+	}
+
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+
+	return snapshot
+}
+
+// Returns the entry for key if it exists and hasn't expired, marking it as
+// recently used.  Must be called with the lock held
+func (c *MemoryDedupCache) live(key string) *memoryEntry {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil
+	}
+
+	c.order.MoveToFront(element)
+	return entry
+}
+
+// Inserts or replaces the entry for key, evicting the least recently used
+// entry when full.  Must be called with the lock held
+func (c *MemoryDedupCache) store(key string, value []byte, ttl time.Duration) {
+	expiresAt := time.Now().Add(ttl)
+	c.version++
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.value = value
+		entry.version = c.version
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryEntry{key: key, value: value, version: c.version, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
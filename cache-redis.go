@@ -0,0 +1,67 @@
+package main
+
+import (
+	"time"
+	"github.com/go-redis/redis"
+)
+
+// Dedup cache backed by redis
+type RedisDedupCache struct {
+	client *redis.Client
+}
+
+// Replaces the value only if it still holds what we read, redis runs scripts
+// atomically so this is our compare-and-swap
+var redisCompareAndSwap = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	return 1
+end
+return 0
+`)
+
+func NewRedisDedupCache(addr, password string, db int) *RedisDedupCache {
+	return &RedisDedupCache{client: redis.NewClient(&redis.Options{Addr: addr, Password: password, DB: db})}
+}
+
+func (c *RedisDedupCache) Name() string {
+	return DEDUP_REDIS
+}
+
+func (c *RedisDedupCache) Get(key string) ([]byte, bool, error) {
+	value, err := c.client.Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+func (c *RedisDedupCache) Set(key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(key, value, ttl).Err()
+}
+
+func (c *RedisDedupCache) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(key, value, ttl).Result()
+}
+
+// Returns nil on a miss, the value we read is the token
+func (c *RedisDedupCache) GetForUpdate(key string) (*CacheEntry, error) {
+	value, found, err := c.Get(key)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &CacheEntry{Value: value, token: string(value)}, nil
+}
+
+func (c *RedisDedupCache) CompareAndSwap(key string, entry *CacheEntry, value []byte, ttl time.Duration) (bool, error) {
+	swapped, err := redisCompareAndSwap.Run(c.client, []string{key}, entry.token, value, int64(ttl / time.Millisecond)).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return swapped == 1, nil
+}
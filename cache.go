@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: DedupCache
+ |--------------------------------------------------------------------------
+ |
+ | The cache used to stop us parsing the same auction line twice and to
+ | remember the last price a seller asked for an item.  One cache is built
+ | at start up from DEDUP_BACKEND and shared by every request:
+ |
+ | memcache - the original behaviour, shared between every service replica
+ | redis    - shared between replicas, for deployments which already run redis
+ | memory   - an in-process LRU with TTL, for tests and single node deployments
+ |            which don't want to run memcached at all
+ |
+ | A miss is reported through the found flag, err is only set when the
+ | backend itself failed.
+ |
+ | Claims have to be atomic because several collectors (and several
+ | service replicas) upload the same lines at the same time.  Add only
+ | stores the value if the key is absent, and CompareAndSwap only replaces
+ | a value if nobody has changed it since it was read with GetForUpdate,
+ | in both cases exactly one caller wins.
+ |
+ */
+
+type DedupCache interface {
+	Name() string
+	Get(key string) (value []byte, found bool, err error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Add(key string, value []byte, ttl time.Duration) (added bool, err error)
+	GetForUpdate(key string) (entry *CacheEntry, err error)
+	CompareAndSwap(key string, entry *CacheEntry, value []byte, ttl time.Duration) (swapped bool, err error)
+}
+
+// Implemented by anything which wants to report a problem on GET /health
+type HealthWarner interface {
+	Warnings() []string
+}
+
+// A value read with GetForUpdate, the token is whatever the backend needs to
+// detect that the value changed underneath us
+type CacheEntry struct {
+	Value []byte
+	token interface{}
+}
+
+const DEDUP_MEMCACHE = "memcache"
+const DEDUP_REDIS = "redis"
+const DEDUP_MEMORY = "memory"
+
+// Builds the dedup cache for the given backend name
+func NewDedupCache(backend string) (DedupCache, error) {
+	// Shared caches fall back to a local one when they become unreachable
+	switch strings.ToLower(strings.TrimSpace(backend)) {
+	case DEDUP_MEMCACHE:
+		return NewFallbackDedupCache(NewMemcacheDedupCache(MC_HOST + ":" + MC_PORT), NewMemoryDedupCache(DEDUP_MEMORY_SIZE)), nil
+	case DEDUP_REDIS:
+		return NewFallbackDedupCache(NewRedisDedupCache(REDIS_HOST + ":" + REDIS_PORT, REDIS_PASS, REDIS_DB), NewMemoryDedupCache(DEDUP_MEMORY_SIZE)), nil
+	case DEDUP_MEMORY:
+		return NewMemoryDedupCache(DEDUP_MEMORY_SIZE), nil
+	}
+
+	return nil, errors.New("Unknown dedup backend: " + backend)
+}
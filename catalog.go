@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Catalog
+ |--------------------------------------------------------------------------
+ |
+ | Every name the parser can match built into a single Aho-Corasick
+ | automaton: the items, their aliases, the bare names the name rules
+ | resolve ("yaulp" for "spell: yaulp") and the keywords the parser looks
+ | for ("wts", "ea", "obo"...).  Each node knows the item or keyword its
+ | text is, so the parser moves one transition per character instead of
+ | asking a trie whether the whole buffer is still a prefix or searching the
+ | buffer for keywords, and looking up a name never has to try the rules
+ | one at a time.
+ |
+ | The failure links let the parser carry on from the longest tail of the
+ | text it just gave up on that could still start a name, which catches
+ | items budged up against each other ("wurmslayerswiftwind").
+ |
+ | A catalog is built once and never changed, the auction controller swaps
+ | in a new one every CATALOG_REFRESH_INTERVAL_IN_SECS (and when a curator
+ | adds an alias) so parsing never races a reload.
+ |
+ */
+
+// The words the parser looks for between items, they are prefixes in the
+// catalog but never items
+var CATALOG_KEYWORDS = []string{
+	"selling", "buying", "wtb", "wts", "wtt", "trading", "trade for", "ea", "each", "per",
+}
+
+// No transition, the text isn't the start of anything in the catalog
+const CATALOG_DEAD = -1
+
+type catalogEdge struct {
+	char byte
+	to   int32
+}
+
+type catalogNode struct {
+	edges []catalogEdge
+	fail  int32
+	depth   int32
+	name    string // the item this text resolves to, empty when it isn't an item (e.g. a keyword)
+	keyword string // the keyword this text is, empty when it isn't one
+}
+
+type Catalog struct {
+	nodes []catalogNode
+	root  [256]int32 // the root's transitions, the root has an edge for most letters
+	Items int
+	Built time.Time
+}
+
+// Builds the automaton from item names, aliases (alias => item name) and the
+// name rules.  An alias wins over an item of the same name, which wins over
+// a name rule, rules are applied in order
+func NewCatalog(items []string, aliases map[string]string, rules NameRules) *Catalog {
+	c := &Catalog{nodes: []catalogNode{{}}, Built: time.Now()}
+	for i := range c.root {
+		c.root[i] = CATALOG_DEAD
+	}
+
+	for _, keyword := range CATALOG_KEYWORDS {
+		c.nodes[c.add(keyword)].keyword = keyword
+	}
+	for word := range NEGOTIATION_WORDS {
+		c.nodes[c.add(word)].keyword = word
+	}
+
+	for _, item := range items {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		c.nodes[c.add(item)].name = item
+		c.Items++
+	}
+	for alias, item := range aliases {
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		if alias != "" {
+			c.nodes[c.add(alias)].name = strings.ToLower(item)
+		}
+	}
+	for _, rule := range rules {
+		for _, item := range items {
+			item = strings.ToLower(strings.TrimSpace(item))
+			if len(item) <= len(rule.Prefix) + len(rule.Suffix) || !strings.HasPrefix(item, rule.Prefix) || !strings.HasSuffix(item, rule.Suffix) {
+				continue
+			}
+			bare := strings.TrimSpace(item[len(rule.Prefix):len(item)-len(rule.Suffix)])
+			if node := c.add(bare); bare != "" && c.nodes[node].name == "" {
+				c.nodes[node].name = item
+			}
+		}
+	}
+
+	c.link()
+	return c
+}
+
+// Adds the text to the automaton and returns the node it ends on
+func (c *Catalog) add(text string) int32 {
+	var state int32 = 0
+	for i := 0; i < len(text); i++ {
+		next := c.Step(int(state), text[i])
+		if next == CATALOG_DEAD {
+			c.nodes = append(c.nodes, catalogNode{depth: c.nodes[state].depth + 1})
+			next = len(c.nodes) - 1
+			if state == 0 {
+				c.root[text[i]] = int32(next)
+			} else {
+				c.nodes[state].edges = append(c.nodes[state].edges, catalogEdge{char: text[i], to: int32(next)})
+			}
+		}
+		state = int32(next)
+	}
+
+	return state
+}
+
+// Sets each node's failure link to the node for the longest proper suffix
+// of its text that is also the start of a name, breadth first so the
+// shorter suffixes are already linked
+func (c *Catalog) link() {
+	var queue []int32
+	for _, next := range c.root {
+		if next != CATALOG_DEAD {
+			c.nodes[next].fail = 0
+			queue = append(queue, next)
+		}
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, edge := range c.nodes[state].edges {
+			c.nodes[edge.to].fail = int32(c.Next(int(c.nodes[state].fail), edge.char))
+			queue = append(queue, edge.to)
+		}
+	}
+}
+
+// Follows the character from the state without falling back, CATALOG_DEAD
+// when the text is no longer the start of any name
+func (c *Catalog) Step(state int, char byte) int {
+	if state == CATALOG_DEAD {
+		return CATALOG_DEAD
+	} else if state == 0 {
+		return int(c.root[char])
+	}
+	for _, edge := range c.nodes[state].edges {
+		if edge.char == char {
+			return int(edge.to)
+		}
+	}
+
+	return CATALOG_DEAD
+}
+
+// Follows the character from the state, falling back along the failure links
+// until some suffix of the text can carry on (the root if none can)
+func (c *Catalog) Next(state int, char byte) int {
+	for state != CATALOG_DEAD {
+		if next := c.Step(state, char); next != CATALOG_DEAD {
+			return next
+		} else if state == 0 {
+			return 0
+		}
+		state = int(c.nodes[state].fail)
+	}
+
+	return 0
+}
+
+// Walks the text from the root, CATALOG_DEAD if it isn't the start of a name
+func (c *Catalog) Walk(text string) int {
+	state := 0
+	for i := 0; i < len(text) && state != CATALOG_DEAD; i++ {
+		state = c.Step(state, text[i])
+	}
+
+	return state
+}
+
+// How many characters of text the state matched
+func (c *Catalog) Depth(state int) int {
+	if state == CATALOG_DEAD {
+		return 0
+	}
+
+	return int(c.nodes[state].depth)
+}
+
+// The item the state's text resolves to, empty if it isn't an item
+func (c *Catalog) Name(state int) string {
+	if state <= 0 {
+		return ""
+	}
+
+	return c.nodes[state].name
+}
+
+// The keyword the state's text is, empty if it isn't one
+func (c *Catalog) Keyword(state int) string {
+	if state <= 0 {
+		return ""
+	}
+
+	return c.nodes[state].keyword
+}
+
+// Whether the text is the start of an item, alias or keyword
+func (c *Catalog) HasPrefix(text string) bool {
+	return c.Walk(text) != CATALOG_DEAD
+}
+
+// Returns the item the text names, through an alias or name rule if need be
+func (c *Catalog) Resolve(text string) (string, bool) {
+	state := c.Walk(text)
+	if state == CATALOG_DEAD || c.nodes[state].name == "" {
+		return "", false
+	}
+
+	return c.nodes[state].name, true
+}
+
+// The number of states in the automaton
+func (c *Catalog) Size() int {
+	return len(c.nodes)
+}
+
+// Builds the catalog from the items, item_aliases and name_rules tables
+func LoadCatalog() *Catalog {
+	var items []string
+	rows := DB.Query("SELECT displayName, id FROM items ORDER BY displayName ASC")
+	if rows != nil {
+		for rows.Next() {
+			var itemName string
+			var itemId int64
+
+			rows.Scan(&itemName, &itemId)
+			if itemId > 0 && itemName != "" {
+				items = append(items, itemName)
+			}
+		}
+		DB.CloseRows(rows)
+	}
+
+	// Aliases curators have added for misspellings and nicknames, see CatalogController
+	aliases := map[string]string{}
+	rows = DB.Query("SELECT item_aliases.alias, items.displayName FROM item_aliases JOIN items ON items.id = item_aliases.item_id")
+	if rows != nil {
+		for rows.Next() {
+			var alias, itemName string
+			if err := rows.Scan(&alias, &itemName); err == nil && alias != "" {
+				aliases[alias] = itemName
+			}
+		}
+		DB.CloseRows(rows)
+	}
+
+	catalog := NewCatalog(items, aliases, LoadNameRules())
+	fmt.Println("Built catalog of " + fmt.Sprint(catalog.Items) + " items with " + fmt.Sprint(len(aliases)) + " aliases")
+	return catalog
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: CatalogRefresher
+ |--------------------------------------------------------------------------
+ |
+ | Rebuilds the auction controller's catalog every
+ | CATALOG_REFRESH_INTERVAL_IN_SECS so new items, aliases and name rules
+ | are picked up without a restart.  A change which should be matched sooner
+ | (e.g. a curator promoting a fragment) marks the catalog dirty and it is
+ | rebuilt within CATALOG_DIRTY_CHECK_INTERVAL_IN_SECS, however many
+ | changes were made in between.
+ |
+ */
+
+type CatalogRefresher struct {
+	stop  chan struct{}
+	done  chan struct{}
+	dirty int32
+}
+
+// Asks for the catalog to be rebuilt at the next dirty check
+func (r *CatalogRefresher) MarkDirty() {
+	atomic.StoreInt32(&r.dirty, 1)
+}
+
+func (r *CatalogRefresher) Start() {
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(time.Second * CATALOG_REFRESH_INTERVAL_IN_SECS)
+		defer ticker.Stop()
+		dirtyCheck := time.NewTicker(time.Second * CATALOG_DIRTY_CHECK_INTERVAL_IN_SECS)
+		defer dirtyCheck.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				atomic.StoreInt32(&r.dirty, 0)
+				AC.RefreshCatalog()
+			case <-dirtyCheck.C:
+				if atomic.CompareAndSwapInt32(&r.dirty, 1, 0) {
+					AC.RefreshCatalog()
+				}
+			}
+		}
+	}()
+}
+
+func (r *CatalogRefresher) Stop() {
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+}
+
+// The catalog is swapped under a lock rather than rebuilt in place so a
+// parse that is part way through keeps the catalog it started with
+type catalogHolder struct {
+	lock    sync.RWMutex
+	catalog *Catalog
+}
+
+// Until the first catalog is loaded the parser only knows the keywords
+func (h *catalogHolder) get() *Catalog {
+	h.lock.RLock()
+	catalog := h.catalog
+	h.lock.RUnlock()
+
+	if catalog == nil {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		if h.catalog == nil {
+			h.catalog = NewCatalog(nil, nil, DefaultNameRules())
+		}
+		catalog = h.catalog
+	}
+	return catalog
+}
+
+func (h *catalogHolder) set(catalog *Catalog) {
+	h.lock.Lock()
+	h.catalog = catalog
+	h.lock.Unlock()
+}
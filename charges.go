@@ -0,0 +1,98 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Charges
+ |--------------------------------------------------------------------------
+ |
+ | Wands, rods and some potions are worth very different amounts depending
+ | on how many charges they have left, sellers write this as
+ | "(5 charges)", "5 chg" or "3/10".  Before the line is parsed each of
+ | these is rewritten to CHARGES_MARKER followed by the count ("#5") which
+ | the price parser records in Item.Charges instead of reading the number
+ | as a price or quantity.
+ |
+ | A bare fraction is only charges in parentheses ("(3/10)") or straight
+ | after the name of an item with nothing but a price or the end of the item
+ | after it ("Rod of Insidious Glamour 3/10 1k"), otherwise it is left alone
+ | ("Bone Chips 1/2 price 10p").
+ |
+ */
+
+const CHARGES_MARKER = "#"
+
+// "(5 charges)", "5 charge", "5chg", "3/10 charges"
+var chargesWordRegex = regexp.MustCompile(`(?i)\(?\b(\d+) ?(?:/ ?\d+ ?)?(?:charges?|chgs?)\b\)?`)
+
+// "3/10" or "(3/10)" on their own
+var chargesFractionRegex = regexp.MustCompile(`\(?\b(\d+) ?/ ?(\d+)\b\)?`)
+
+// The longest item name, in words, looked for in front of a fraction
+const CHARGES_MAX_NAME_WORDS = 8
+
+// Rewrites every charge count in the line to CHARGES_MARKER and the count,
+// this has to run before "/" is stripped from the line.  The catalog is used
+// to tell whether a fraction follows an item name, without one only
+// fractions in parentheses are read as charges
+func NormaliseCharges(line string, catalog *Catalog) string {
+	// Most lines have no charges at all, both forms need a "/" or "ch"
+	if !strings.Contains(line, "/") && !strings.Contains(strings.ToLower(line), "ch") {
+		return line
+	}
+
+	line = chargesWordRegex.ReplaceAllString(line, " " + CHARGES_MARKER + "$1 ")
+
+	var out strings.Builder
+	last := 0
+	for _, match := range chargesFractionRegex.FindAllStringSubmatchIndex(line, -1) {
+		left, _ := strconv.Atoi(line[match[2]:match[3]])
+		right, _ := strconv.Atoi(line[match[4]:match[5]])
+		parenthesised := line[match[0]] == '(' && line[match[1] - 1] == ')'
+		if right == 0 || left > right {
+			continue
+		}
+		if !parenthesised && !(followsItemName(line[last:match[0]], catalog) && endsItem(line[match[1]:])) {
+			continue
+		}
+
+		out.WriteString(line[last:match[0]])
+		out.WriteString(" " + CHARGES_MARKER + line[match[2]:match[3]] + " ")
+		last = match[1]
+	}
+	out.WriteString(line[last:])
+
+	return out.String()
+}
+
+// Whether the text after a fraction is the end of the item, i.e. nothing, a
+// separator or a price
+func endsItem(text string) bool {
+	text = strings.TrimLeft(text, " ")
+	return text == "" || strings.IndexAny(text[0:1], ",;|&/0123456789") == 0
+}
+
+// Whether the text ends with the name of an item (or an alias), up to the
+// last separator
+func followsItemName(text string, catalog *Catalog) bool {
+	if catalog == nil {
+		return false
+	}
+	if i := strings.LastIndexAny(text, ",;|&"); i >= 0 {
+		text = text[i+1:]
+	}
+
+	words := strings.Fields(strings.ToLower(text))
+	for n := 1; n <= len(words) && n <= CHARGES_MAX_NAME_WORDS; n++ {
+		if _, ok := catalog.Resolve(strings.Join(words[len(words) - n:], " ")); ok {
+			return true
+		}
+	}
+
+	return false
+}
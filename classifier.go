@@ -0,0 +1,92 @@
+package main
+
+import (
+	"regexp"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Line classifier
+ |--------------------------------------------------------------------------
+ |
+ | Not everything said in /auction is an auction.  Before we look for items
+ | each line is labelled as one of:
+ |
+ |   listing      "WTS Cloak of Flames 3k", parsed and stored as normal
+ |   price_check  "PC Cloak of Flames?", "anyone know price of Ale", the
+ |                items are counted in price_checks as demand
+ |   question     "anyone know where the ferry is?", skipped
+ |   lfg          "LF group", "LFM for Sol B", skipped
+ |   spam         links, plat sellers and key mashing, skipped
+ |
+ | Spam is checked first.  A line which says WTS/WTB/WTT (or selling, buying,
+ | trading) is never a price check, question or LFG, and a question with a
+ | price in it is taken as a listing.  Anything we can't place is a listing
+ | since plenty of people just auction "Ale".
+ |
+ */
+
+const (
+	LINE_LISTING     = "listing"
+	LINE_PRICE_CHECK = "price_check"
+	LINE_QUESTION    = "question"
+	LINE_LFG         = "lfg"
+	LINE_SPAM        = "spam"
+)
+
+// Links and gold sellers
+var spamRegex = regexp.MustCompile(`(?i)(https?://|www\.|\.(com|net|org)\b|cheap plat|buy plat|plat for \$|\$\d)`)
+
+// A single character repeated this many times in a row is key mashing
+const SPAM_REPEATED_CHARS = 10
+
+var lfgRegex = regexp.MustCompile(`(?i)\b(lfg|lf\d?m|lf (a )?(group|grp|guild|tank|healer|cleric|puller|enc|enchanter|shaman|bard)|looking for (a )?(group|grp|guild))\b`)
+
+var priceCheckRegex = regexp.MustCompile(`(?i)(^\s*(pc|p\.c\.?)\b|price ?check|price of|how much (is|are|for|do|does|would|'?s)\b|what('?s| is| are) .*(worth|go(ing)? for|sell(ing)? for)|anyone know (the )?(price|value))`)
+
+var questionRegex = regexp.MustCompile(`(?i)(\?\s*$|^\s*(who|what|where|when|why|how|anyone|does|do|is|are|can|could)\b)`)
+
+// Words which mark the line as someone actually buying, selling or trading
+var listingKeywordRegex = regexp.MustCompile(`(?i)\b(wts|wtb|wtt|selling|buying|trading|trade for)\b`)
+
+var listingPriceRegex = regexp.MustCompile(`(?i)\d(\.\d+)? ?(pp|p|k|gp|plat)\b`)
+
+// Labels a line, text is the part of the line inside the quotes
+func ClassifyLine(text string) string {
+	if spamRegex.MatchString(text) || hasRepeatedChars(text, SPAM_REPEATED_CHARS) {
+		return LINE_SPAM
+	}
+
+	if listingKeywordRegex.MatchString(text) {
+		return LINE_LISTING
+	}
+	if lfgRegex.MatchString(text) {
+		return LINE_LFG
+	}
+	if priceCheckRegex.MatchString(text) {
+		return LINE_PRICE_CHECK
+	}
+	if questionRegex.MatchString(text) && !listingPriceRegex.MatchString(text) {
+		return LINE_QUESTION
+	}
+
+	return LINE_LISTING
+}
+
+func hasRepeatedChars(text string, limit int) bool {
+	run := 0
+	var previous rune
+	for _, r := range text {
+		if r == previous && r != ' ' {
+			run++
+			if run >= limit {
+				return true
+			}
+		} else {
+			run = 1
+		}
+		previous = r
+	}
+
+	return false
+}
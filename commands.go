@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Commands
+ |--------------------------------------------------------------------------
+ |
+ | One off commands run instead of the web server when the binary is given
+ | arguments, e.g.
+ |
+ |   ./service-collection explain "[Mon Jan 2 15:04:05 2006] Soandso auctions, 'WTS Ale 5p'"
+ |   ./service-collection corpus
+ |
+ | Each command returns the process exit code.
+ |
+ */
+
+type Command struct {
+	usage string
+	run   func(args []string) int
+}
+
+var COMMANDS = map[string]Command{
+	"explain": {"explain [-server RED|BLUE] <line>: show how the parser reads a line", explainCommand},
+	"corpus":  {"corpus [-items file] [-aliases file] [-parser version] [-show] [corpus file]: check the parser against the golden corpus", corpusCommand},
+}
+
+func RunCommand(name string, args []string) int {
+	command, ok := COMMANDS[name]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Unknown command: " + name + ", available commands are:")
+		for _, c := range COMMANDS {
+			fmt.Fprintln(os.Stderr, "  " + c.usage)
+		}
+		return 2
+	}
+
+	return command.run(args)
+}
+
+func explainCommand(args []string) int {
+	server := "BLUE"
+	if len(args) > 1 && args[0] == "-server" {
+		server = strings.ToUpper(args[1])
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: explain [-server RED|BLUE] <line>")
+		return 2
+	}
+
+	DB.Open()
+	defer DB.Close()
+
+	AC.RefreshCatalog()
+	trace := AC.Explain(strings.Join(args, " "), server)
+	fmt.Print(trace.String())
+	if trace.Error != "" {
+		return 1
+	}
+
+	return 0
+}
+
+// Reads the non blank lines of a file
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// Checks the parser against the golden corpus, see corpus.go.  Exits with 1
+// if any entry that isn't pending fails, -show prints what the parser made of
+// each failing line as a corpus entry to make updating them easier.  The
+// active parser is checked unless -parser names another version
+func corpusCommand(args []string) int {
+	flags := flag.NewFlagSet("corpus", flag.ContinueOnError)
+	itemsFile := flags.String("items", CORPUS_ITEMS_FILE, "file of item names, one per line")
+	aliasesFile := flags.String("aliases", CORPUS_ALIASES_FILE, "file of \"alias => item\" lines")
+	version := flags.String("parser", ACTIVE_PARSER, "parser version to check, see parsers.go")
+	show := flags.Bool("show", false, "print what the parser made of each failing line")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: corpus [-items file] [-aliases file] [-parser version] [-show] [corpus file]")
+		return 2
+	}
+	if _, err := LookupParser(*version); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	corpusFile := CORPUS_FILE
+	if flags.NArg() == 1 {
+		corpusFile = flags.Arg(0)
+	}
+
+	entries, err := LoadCorpus(corpusFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not read the corpus: ", err)
+		return 1
+	}
+	catalog, err := LoadCorpusCatalog(*itemsFile, *aliasesFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not read the corpus catalog: ", err)
+		return 1
+	}
+	AC.SetCatalog(catalog)
+
+	passed, failed, pending, fixed := 0, 0, 0, 0
+	for _, entry := range entries {
+		result := AC.CheckCorpusEntry(entry, *version)
+		switch {
+		case result.Passed() && entry.Pending != "":
+			fixed++
+			fmt.Printf("FIXED   %s:%d %s\n        now passes, remove pending: %s\n", corpusFile, entry.number, entry.Line, entry.Pending)
+			continue
+		case result.Passed():
+			passed++
+			continue
+		case entry.Pending != "":
+			pending++
+			fmt.Printf("PENDING %s:%d %s\n        %s\n", corpusFile, entry.number, entry.Line, entry.Pending)
+		default:
+			failed++
+			fmt.Printf("FAIL    %s:%d %s\n", corpusFile, entry.number, entry.Line)
+		}
+
+		for _, diff := range result.Diffs {
+			fmt.Println("        " + diff)
+		}
+		if *show {
+			got, _ := json.Marshal(AC.CorpusEntryFor(entry.Line, entry.Server, *version))
+			fmt.Println("        got: " + string(got))
+		}
+	}
+
+	fmt.Printf("\n%d passed, %d failed, %d pending, %d pending now pass\n", passed, failed, pending, fixed)
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
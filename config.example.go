@@ -15,9 +15,38 @@ const WIKI_SERVICE_PORT = "8081"
 const GATEKEEPER_SERVICE_HOST = "localhost"
 const GATEKEEPER_SERVICE_PORT = "8085"
 
+// Emails allowed to call the endpoints which change state (requeueing dead
+// deliveries, curating the catalog, reprocessing), see auth.go
+var ADMIN_EMAILS = []string{}
+
 const RELAY_SERVICE_HOST = "localhost"
 const RELAY_SERVICE_PORT = "3000"
 
+// Where the auction stream is published, any of "relay", "sqs" and "nats"
+var PUBLISHERS = []string{"relay"}
+
+// Per server topics for each publisher: the relay path segment, the SQS queue
+// URL or the NATS subject.  Servers left out use the publisher's default
+// (lower case server name for the relay, auctions.<server> for NATS), SQS
+// has no default so every server needs a queue URL or the service won't start
+var PUBLISHER_TOPICS = map[string]map[string]string{
+	"relay": {"RED": "red", "BLUE": "blue"},
+	"sqs":   {"RED": "http://localhost:9324/queue/auctions-red", "BLUE": "http://localhost:9324/queue/auctions-blue"},
+	"nats":  {"RED": "auctions.red", "BLUE": "auctions.blue"},
+}
+
+// Auction event schema version sent to each publisher, see t-auction-event.go.
+// The relay front end still expects version 1
+var PUBLISHER_SCHEMA_VERSIONS = map[string]int{"relay": 1, "sqs": 2, "nats": 2}
+
+// SQS config, leave the keys empty when talking to a local ElasticMQ
+const SQS_REGION = "us-east-1"
+const SQS_ACCESS_KEY = ""
+const SQS_SECRET_KEY = ""
+
+// NATS config
+const NATS_URL = "nats://localhost:4222"
+
 // SQL DB Config
 const SQL_HOST = "";
 const SQL_PORT = "";
@@ -28,10 +57,92 @@ const SQL_DB   = ""
 const MAX_CONNECTIONS = 20
 const PORT = "8080"
 
+// Dedup cache backend, one of "memcache", "redis" or "memory" (in-process,
+// for tests and single node deployments)
+const DEDUP_BACKEND = "memcache"
+const DEDUP_MEMORY_SIZE = 100000
+const DEDUP_PROBE_INTERVAL_IN_SECS = 10 // how often an unreachable memcache/redis is retried
+const DEDUP_CLAIM_ATTEMPTS = 3 // times we retry a price change claim when another collector changes it first
+
 // Memcached Config
 const MC_HOST = "";
 const MC_PORT = "";
 
-const CACHE_TIME_IN_SECS = 60 * 60 * 3 // Prevents other loggers from sending the same/old log data, this lane lives in cache for 3 hours
+// Redis Config
+const REDIS_HOST = "localhost"
+const REDIS_PORT = "6379"
+const REDIS_PASS = ""
+const REDIS_DB = 0
+
+// Lines with the same seller and text whose log timestamps fall within the
+// server's window are treated as one auction seen by several clients, keys
+// are kept for DEDUP_LINE_RETENTION_IN_SECS so late uploads of old logs are
+// still merged
+var DEDUP_WINDOW_IN_SECS = map[string]int{"RED": 60, "BLUE": 60}
+const DEDUP_DEFAULT_WINDOW_IN_SECS = 60
+const DEDUP_LINE_RETENTION_IN_SECS = 60 * 60 * 24
 const SALE_CACHE_TIME_IN_SECS = 60 * 30
+
+// Prefix and suffix pairs which turn a bare item name into its full name, e.g.
+// "yaulp" into "spell: yaulp".  More can be added to the name_rules table
+// without a deploy, see name-rules.go
+var NAME_RULES = [][2]string{
+	{"spell: ", ""},
+	{"rune of ", ""},
+	{"rune of the ", ""},
+	{"words of ", ""},
+	{"words of the ", ""},
+}
+
+// How often the catalog of items, aliases and name rules is rebuilt, see catalog.go
+const CATALOG_REFRESH_INTERVAL_IN_SECS = 60 * 5
+const CATALOG_DIRTY_CHECK_INTERVAL_IN_SECS = 10 // how soon a curated alias is picked up
+
+// Parser version whose items are stored, and a candidate version run next to
+// it on every line with only its differences kept (empty for none), see
+// parsers.go and shadow.go
+const ACTIVE_PARSER = "1"
+const SHADOW_PARSER = ""
+
+// How often the reprocessor looks for a queued job and how many stored
+// sightings it re-parses between saving its progress, see reprocess.go
+const REPROCESS_POLL_INTERVAL_IN_SECS = 30
+const REPROCESS_BATCH_SIZE = 500
+
+// Example lines kept for each unmatched fragment, see fragments.go
+const FRAGMENT_EXAMPLES_KEPT = 5
+
+// Denomination a bare decimal price such as "1.5" is read in, see denominations.go
+const BARE_DECIMAL_DENOMINATION = "pp"
+
+// How much a price counts towards price statistics when it is a range or has
+// a negotiation flag, the weights of every flag on an item are multiplied
+// together and 0 leaves the price out altogether, see negotiation.go
+var PRICE_STATISTIC_WEIGHTS = map[string]float32{"range": 0.5, "obo": 0.75, "offers": 0, "firm": 1, "pst": 1}
+
+// Sale detector config, a listing advertised at least SALE_MIN_SIGHTINGS times
+// which then isn't seen for SALE_IDLE_IN_SECS is recorded as an inferred sale
+const SALE_IDLE_IN_SECS = 60 * 60 * 2
+const SALE_MIN_SIGHTINGS = 3
+const SALE_DETECTOR_INTERVAL_IN_SECS = 60 * 5
+const SALE_DETECTOR_BATCH_SIZE = 500
+
+// Worker pool config, uploads are rejected with a 503 once the queue is full
+const WORKER_POOL_SIZE = 8
+const WORKER_QUEUE_SIZE = 64
+const WORKER_RETRY_AFTER_IN_SECS = 30
+
+// Outbox config for relay and wiki deliveries, failed events are retried with
+// exponential backoff and dead-lettered after OUTBOX_MAX_ATTEMPTS
+const OUTBOX_POLL_INTERVAL_IN_SECS = 2
+const OUTBOX_BATCH_SIZE = 100
+const OUTBOX_LEASE_IN_SECS = 60
+// A batch stops handing out events this long before its lease runs out, more
+// than a delivery can take (the relay, SQS and wiki clients time out at 10s)
+const OUTBOX_LEASE_MARGIN_IN_SECS = 15
+const OUTBOX_MAX_ATTEMPTS = 10
+const OUTBOX_BACKOFF_BASE_IN_SECS = 2
+const OUTBOX_BACKOFF_MAX_IN_SECS = 60 * 10
+const OUTBOX_BREAKER_THRESHOLD = 5
+const OUTBOX_BREAKER_COOLDOWN_IN_SECS = 30
  */
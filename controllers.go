@@ -5,4 +5,6 @@ package main
 type Controller interface {}
 
 // Instantiate all controllers here so that we can bind them to our routes
-var AC = new(AuctionController)
\ No newline at end of file
+var AC = new(AuctionController)
+var ADC = new(AdminController)
+var CC = new(CatalogController)
\ No newline at end of file
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Golden corpus
+ |--------------------------------------------------------------------------
+ |
+ | Real auction lines along with what the parser should make of them, run
+ | with the "corpus" command (or go test, see corpus_test.go) before changing
+ | the parser.  Each line of the corpus file is a JSON entry:
+ |
+ |   {"line": "WTS 10 Bone Chips 1p ea", "label": "listing", "items": [
+ |     {"name": "bone chips", "price": 1, "lotPrice": 10, "priceBasis": "unit",
+ |      "quantity": 10, "intent": "sell"}]}
+ |
+ | Lines starting with // and blank lines are skipped.  The line can be a
+ | full log line or just the text inside the quotes.  Item fields which are
+ | left out are expected to be empty, apart from quantity (1), priceBasis
+ | (lot), intent (sell) and priceMin/priceMax (the price).
+ |
+ | Lines are read the same way parseLine reads them, against the fixed
+ | catalog in corpus/items.txt and corpus/aliases.txt ("alias => item",
+ | // for comments) rather than the items table, so the expectations don't move when the catalog does.  Only
+ | listings and price checks keep their items.
+ |
+ | An entry with "pending" set is a case the parser is known to get wrong
+ | (the reason goes in pending), it is reported but doesn't fail the run
+ | until it starts passing, when pending should be removed.
+ |
+ */
+
+const CORPUS_FILE = "corpus/auctions.jsonl"
+const CORPUS_ITEMS_FILE = "corpus/items.txt"
+const CORPUS_ALIASES_FILE = "corpus/aliases.txt"
+
+// Prices within this fraction of each other (or this much platinum for prices
+// under 1p) are the same price, float32 can't hold a big price exactly
+const CORPUS_PRICE_TOLERANCE = 0.0005
+
+type CorpusEntry struct {
+	Line    string       `json:"line"`
+	Server  string       `json:"server,omitempty"`
+	Label   string       `json:"label,omitempty"`
+	Items   []CorpusItem `json:"items"`
+	Pending string       `json:"pending,omitempty"`
+	Note    string       `json:"note,omitempty"`
+	number  int          // line number in the corpus file
+}
+
+type CorpusItem struct {
+	Name        string   `json:"name"`
+	Price       float32  `json:"price,omitempty"`
+	LotPrice    float32  `json:"lotPrice,omitempty"`
+	PriceBasis  string   `json:"priceBasis,omitempty"`
+	PriceMin    *float32 `json:"priceMin,omitempty"`
+	PriceMax    *float32 `json:"priceMax,omitempty"`
+	Negotiation []string `json:"negotiation,omitempty"`
+	Quantity    int16    `json:"quantity,omitempty"`
+	Charges     int16    `json:"charges,omitempty"`
+	Intent      string   `json:"intent,omitempty"`
+}
+
+// A field of an item (or the line's label) which came out differently,
+// Item is -1 for the label
+type FieldDiff struct {
+	Item     int
+	Field    string
+	Expected string
+	Got      string
+}
+
+func (d FieldDiff) String() string {
+	if d.Item < 0 {
+		return d.Field + ": expected " + d.Expected + ", got " + d.Got
+	} else if d.Field == "" {
+		return fmt.Sprintf("items[%d]: expected %s, got %s", d.Item, d.Expected, d.Got)
+	}
+
+	return fmt.Sprintf("items[%d].%s: expected %s, got %s", d.Item, d.Field, d.Expected, d.Got)
+}
+
+type CorpusResult struct {
+	Entry CorpusEntry
+	Label string
+	Diffs []string
+}
+
+func (r CorpusResult) Passed() bool {
+	return len(r.Diffs) == 0
+}
+
+// Reads the corpus entries from a file, see the format above
+func LoadCorpus(path string) ([]CorpusEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []CorpusEntry
+	scanner := bufio.NewScanner(file)
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "//") {
+			continue
+		}
+
+		var entry CorpusEntry
+		if err := json.Unmarshal([]byte(text), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, number, err)
+		}
+		entry.number = number
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// Reads the fixed catalog the corpus is checked against, the aliases file
+// holds "alias => item" lines and is optional
+func LoadCorpusCatalog(itemsPath, aliasesPath string) (*Catalog, error) {
+	items, err := readLines(itemsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := map[string]string{}
+	if lines, err := readLines(aliasesPath); err == nil {
+		for _, line := range lines {
+			parts := strings.SplitN(line, "=>", 2)
+			if len(parts) == 2 && !strings.HasPrefix(strings.TrimSpace(line), "//") {
+				aliases[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return NewCatalog(items, aliases, DefaultNameRules()), nil
+}
+
+// Parses the entry's line with the parser version given and compares what
+// came out field by field
+func (c *AuctionController) CheckCorpusEntry(entry CorpusEntry, version string) CorpusResult {
+	result := CorpusResult{Entry: entry}
+	server := entry.Server
+	if server == "" {
+		server = "BLUE"
+	}
+
+	auction, err := c.readLine(entry.Line, server, version, nil)
+	if err != nil {
+		result.Diffs = append(result.Diffs, "unreadable: " + err.Error())
+		return result
+	}
+	result.Label = auction.label
+
+	// parseLine drops the items of anything but a listing or a price check
+	items := auction.Items
+	if auction.label != LINE_LISTING && auction.label != LINE_PRICE_CHECK {
+		items = nil
+	}
+
+	var diffs []FieldDiff
+	if entry.Label != "" && entry.Label != auction.label {
+		diffs = append(diffs, FieldDiff{Item: -1, Field: "label", Expected: entry.Label, Got: auction.label})
+	}
+	diffs = append(diffs, DiffItems(entry.Items, items)...)
+	for _, diff := range diffs {
+		result.Diffs = append(result.Diffs, diff.String())
+	}
+
+	return result
+}
+
+// Compares parsed items against the items expected, in order
+func DiffItems(expected []CorpusItem, items []Item) []FieldDiff {
+	var diffs []FieldDiff
+	for i := 0; i < len(expected) || i < len(items); i++ {
+		if i >= len(items) {
+			diffs = append(diffs, FieldDiff{Item: i, Expected: expected[i].Name, Got: "nothing"})
+			continue
+		} else if i >= len(expected) {
+			diffs = append(diffs, FieldDiff{Item: i, Expected: "nothing", Got: strings.TrimSpace(items[i].Name)})
+			continue
+		}
+		for _, diff := range expected[i].diff(items[i]) {
+			diff.Item = i
+			diffs = append(diffs, diff)
+		}
+	}
+
+	return diffs
+}
+
+// The fields of the parsed item which don't match the expected item
+func (expected CorpusItem) diff(item Item) []FieldDiff {
+	var diffs []FieldDiff
+	check := func(field string, want, got interface{}) {
+		if fmt.Sprint(want) != fmt.Sprint(got) {
+			diffs = append(diffs, FieldDiff{Field: field, Expected: fmt.Sprint(want), Got: fmt.Sprint(got)})
+		}
+	}
+	checkPrice := func(field string, want, got float32) {
+		if math.Abs(float64(want - got)) > CORPUS_PRICE_TOLERANCE * math.Max(1, math.Abs(float64(want))) {
+			diffs = append(diffs, FieldDiff{Field: field, Expected: FormatPlatinum(want), Got: FormatPlatinum(got)})
+		}
+	}
+
+	quantity, basis, intent := expected.Quantity, expected.PriceBasis, expected.Intent
+	if quantity == 0 {
+		quantity = 1
+	}
+	if basis == "" {
+		basis = PRICE_BASIS_LOT
+	}
+	if intent == "" {
+		intent = INTENT_SELL
+	}
+	priceMin, priceMax := expected.Price, expected.Price
+	if expected.PriceMin != nil {
+		priceMin = *expected.PriceMin
+	}
+	if expected.PriceMax != nil {
+		priceMax = *expected.PriceMax
+	}
+
+	check("name", strings.ToLower(expected.Name), strings.TrimSpace(item.Name))
+	checkPrice("price", expected.Price, item.Price)
+	checkPrice("lotPrice", expected.LotPrice, item.LotPrice)
+	checkPrice("priceMin", priceMin, item.PriceMin)
+	checkPrice("priceMax", priceMax, item.PriceMax)
+	check("priceBasis", basis, item.PriceBasis)
+	check("quantity", quantity, item.Quantity)
+	check("charges", expected.Charges, item.Charges)
+	check("intent", intent, item.intent)
+	check("negotiation", strings.Join(expected.Negotiation, ","), strings.Join(item.Negotiation, ","))
+
+	return diffs
+}
+
+// What the parser version given makes of a line, as a corpus entry
+func (c *AuctionController) CorpusEntryFor(line, server, version string) CorpusEntry {
+	entry := CorpusEntry{Line: line, Server: server, Items: []CorpusItem{}}
+	if server == "" {
+		server = "BLUE"
+	}
+
+	auction, err := c.readLine(line, server, version, nil)
+	if err != nil {
+		return entry
+	}
+	entry.Label = auction.label
+	if auction.label != LINE_LISTING && auction.label != LINE_PRICE_CHECK {
+		return entry
+	}
+
+	for _, item := range auction.Items {
+		entry.Items = append(entry.Items, NewCorpusItem(item))
+	}
+
+	return entry
+}
+
+// The parsed item as an expectation, fields at their default are left out
+func NewCorpusItem(item Item) CorpusItem {
+	expected := CorpusItem{
+		Name: strings.TrimSpace(item.Name),
+		Price: item.Price,
+		LotPrice: item.LotPrice,
+		Negotiation: item.Negotiation,
+		Charges: item.Charges,
+	}
+	if item.PriceBasis != PRICE_BASIS_LOT {
+		expected.PriceBasis = item.PriceBasis
+	}
+	if item.Quantity != 1 {
+		expected.Quantity = item.Quantity
+	}
+	if item.intent != INTENT_SELL {
+		expected.Intent = item.intent
+	}
+	if item.PriceMin != item.Price {
+		priceMin := item.PriceMin
+		expected.PriceMin = &priceMin
+	}
+	if item.PriceMax != item.Price {
+		priceMax := item.PriceMax
+		expected.PriceMax = &priceMax
+	}
+
+	return expected
+}
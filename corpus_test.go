@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Checks the active parser against every entry of the golden corpus, the same
+// as the corpus command.  Pending entries are logged rather than failed
+func TestCorpus(t *testing.T) {
+	entries, err := LoadCorpus(CORPUS_FILE)
+	if err != nil {
+		t.Fatalf("Could not read the corpus: %s", err)
+	}
+	catalog, err := LoadCorpusCatalog(CORPUS_ITEMS_FILE, CORPUS_ALIASES_FILE)
+	if err != nil {
+		t.Fatalf("Could not read the corpus catalog: %s", err)
+	}
+	c := new(AuctionController)
+	c.SetCatalog(catalog)
+
+	for _, entry := range entries {
+		entry := entry
+		t.Run(fmt.Sprintf("line-%d", entry.number), func(t *testing.T) {
+			result := c.CheckCorpusEntry(entry, ACTIVE_PARSER)
+			switch {
+			case entry.Pending != "" && result.Passed():
+				t.Logf("%s now passes, remove pending: %s", entry.Line, entry.Pending)
+			case entry.Pending != "":
+				t.Logf("%s is pending: %s", entry.Line, entry.Pending)
+			case !result.Passed():
+				for _, diff := range result.Diffs {
+					t.Errorf("%s: %s", entry.Line, diff)
+				}
+			}
+		})
+	}
+}
@@ -110,6 +110,46 @@ func (d *Database) Insert(query string, parameters ...interface{}) (int64, error
 	return -1, err
 }
 
+// Runs an UPDATE or DELETE style statement and returns the number of rows it
+// affected, unlike Insert this doesn't open a transaction
+func (d *Database) Exec(query string, parameters ...interface{}) (int64, error) {
+	LogInDebugMode("running exec query: ", query)
+	if d.conn == nil {
+		fmt.Println("Spawning a new connection")
+		d.Open()
+	}
+
+	res, err := d.conn.Exec(query, parameters...)
+	if err != nil {
+		fmt.Println("Exec err: ", err.Error())
+		return -1, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Runs the statements in fn in a single transaction, committing if fn
+// returns nil and rolling back otherwise
+func (d *Database) Transaction(fn func(tx *sql.Tx) error) error {
+	if d.conn == nil {
+		fmt.Println("Spawning a new connection")
+		d.Open()
+	}
+
+	tx, err := d.conn.Begin()
+	if err != nil {
+		fmt.Println("Error creating transaction: ", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (d *Database) Close() {
 	if d.conn != nil {
 		fmt.Println("Closing DB connection")
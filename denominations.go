@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Denominations
+ |--------------------------------------------------------------------------
+ |
+ | Every coin and shorthand a seller might put after a price, with its value
+ | in copper.  Prices are normalised to copper while parsing and reported in
+ | platinum (1pp = 10gp = 100sp = 1000cp), "k" and "m" are thousands and
+ | millions of platinum.
+ |
+ | A price with no denomination is platinum, a bare decimal such as "1.5" is
+ | read in BARE_DECIMAL_DENOMINATION (platinum by default, servers where
+ | "1.5" means 1.5k can set it to "k").
+ |
+ */
+
+const COPPER_PER_PLATINUM = 1000
+
+var DENOMINATIONS = map[string]int64{
+	"cp":       1,
+	"copper":   1,
+	"sp":       10,
+	"silver":   10,
+	"gp":       100,
+	"gold":     100,
+	"p":        COPPER_PER_PLATINUM,
+	"pp":       COPPER_PER_PLATINUM,
+	"plat":     COPPER_PER_PLATINUM,
+	"platinum": COPPER_PER_PLATINUM,
+	"k":        COPPER_PER_PLATINUM * 1000,
+	"m":        COPPER_PER_PLATINUM * 1000000,
+}
+
+// Prefixes of the denominations which aren't a denomination themselves, e.g.
+// "g" on the way to "gp" or "pla" on the way to "plat".  The parser reads a
+// character at a time so these mean "keep reading"
+var partialDenominations = func() map[string]bool {
+	partial := map[string]bool{}
+	for name := range DENOMINATIONS {
+		for i := 1; i < len(name); i++ {
+			if _, full := DENOMINATIONS[name[0:i]]; !full {
+				partial[name[0:i]] = true
+			}
+		}
+	}
+	return partial
+}()
+
+// Matches a price or quantity on its own, e.g. "5k", "x10", "10x", "1.5", "50gp",
+// a price range such as "4-5k" or "4k-5k" (groups 4 to 6 are the upper end) or
+// a charge count "#5" written by NormaliseCharges
+var priceRegex = regexp.MustCompile(`(?i)^([x#] ?)?(\d*\.?\d*)( ?[a-z]+)?(?:(-)(\d*\.?\d*)( ?[a-z]+)?)?$`)
+
+// A bare decimal like "1.5" with no denomination
+var bareDecimalRegex = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+// Joins an abbreviated denomination onto the number before it so "5 pp" is
+// read the same as "5pp".  Full words like "gold" are just as likely to be
+// the start of an item name ("5 Gold Rings"), so they are only joined when
+// the price ends there, i.e. the end of the line, punctuation or "ea"/"obo"
+var spacedDenominationRegex = regexp.MustCompile(`(?i)(\d) (pp|plat|gp|sp|cp|p|k|m)\b`)
+var spacedWordDenominationRegex = regexp.MustCompile(`(?i)(\d) (platinum|gold|silver|copper)( (?:ea|each|per|obo|firm|or|pst)\b| ?[,/|;:)!.&+-]| *$)`)
+
+func JoinSpacedDenominations(line string) string {
+	line = spacedDenominationRegex.ReplaceAllString(line, "$1$2")
+	return spacedWordDenominationRegex.ReplaceAllString(line, "$1$2$3")
+}
+
+// Closes up the spaces around the dash in a price range so "4 - 5k" is read
+// the same as "4-5k"
+var spacedRangeRegex = regexp.MustCompile(`(?i)(\d[a-z]*) ?- ?(\d)`)
+
+func JoinPriceRanges(line string) string {
+	return spacedRangeRegex.ReplaceAllString(line, "$1-$2")
+}
+
+// Looks up a denomination, returning its value in copper.  partial is true
+// when the text is only the start of a denomination
+func LookupDenomination(text string) (copper int64, partial bool, ok bool) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if copper, ok := DENOMINATIONS[text]; ok {
+		return copper, false, true
+	}
+
+	return 0, partialDenominations[text], false
+}
+
+// Converts an amount in the given denomination to copper
+func ToCopper(amount float64, copperPerUnit int64) int64 {
+	return int64(math.Round(amount * float64(copperPerUnit)))
+}
+
+// Converts copper to the platinum we report prices in
+func CopperToPlatinum(copper int64) float32 {
+	return float32(float64(copper) / COPPER_PER_PLATINUM)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Unmatched fragments
+ |--------------------------------------------------------------------------
+ |
+ | Text in a listing which isn't an item we know, a price or part of how
+ | auctions are written ("WTS", "obo", "each"...) is most likely an item
+ | missing from the catalog or a common misspelling of one.  Each fragment
+ | is counted per server in unmatched_fragments along with a few example
+ | lines, a curator reviews them through /catalog/fragments and promotes
+ | them to a new item (looked up by the wiki service) or an alias of an
+ | existing item, see CatalogController.
+ |
+ */
+
+const (
+	FRAGMENT_NEW      = "new"
+	FRAGMENT_PROMOTED = "promoted"
+	FRAGMENT_IGNORED  = "ignored"
+)
+
+// Words which end a fragment, they are how a listing is written rather than
+// part of an item name
+var fragmentBreakWords = map[string]bool{
+	"wts": true, "wtb": true, "wtt": true, "selling": true, "buying": true, "trading": true,
+	"trade": true, "for": true, "or": true, "and": true, "also": true, "with": true,
+	"ea": true, "each": true, "per": true, "x": true, "all": true, "only": true,
+	"obo": true, "ono": true, "firm": true, "offer": true, "offers": true, "pst": true,
+	"taking": true, "cheap": true, "pc": true, "price": true, "lf": true, "tell": true,
+	"send": true, "me": true, "charges": true, "charge": true, "chg": true,
+}
+
+// Words which can sit inside an item name ("Words of the Ancients") but are
+// trimmed from either end of a fragment
+var fragmentJoinWords = map[string]bool{
+	"a": true, "an": true, "the": true, "of": true,
+}
+
+// Fragments shorter than this (in letters) are too vague to review
+const FRAGMENT_MIN_LETTERS = 3
+
+// Longer runs are sentences rather than an item name
+const FRAGMENT_MAX_WORDS = 6
+
+// Returns the runs of text in the line which weren't matched as an item,
+// price or listing keyword, lower case and in order
+func (a *Auction) UnmatchedFragments() []string {
+	var fragments []string
+
+	for _, segment := range a.Segments() {
+		if segment.Type != SEGMENT_TEXT {
+			continue
+		}
+
+		var run []string
+		flush := func() {
+			for len(run) > 0 && fragmentJoinWords[run[0]] {
+				run = run[1:]
+			}
+			for len(run) > 0 && fragmentJoinWords[run[len(run)-1]] {
+				run = run[:len(run)-1]
+			}
+
+			fragment := strings.Join(run, " ")
+			letters := 0
+			for _, r := range fragment {
+				if unicode.IsLetter(r) {
+					letters++
+				}
+			}
+			if letters >= FRAGMENT_MIN_LETTERS && len(run) <= FRAGMENT_MAX_WORDS {
+				fragments = append(fragments, fragment)
+			}
+			run = nil
+		}
+
+		// Punctuation (commas between items, brackets) ends a fragment as well
+		pieces := strings.FieldsFunc(strings.ToLower(segment.Text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) && r != '\'' && r != ':'
+		})
+		for _, piece := range pieces {
+			for _, word := range strings.Fields(piece) {
+				word = strings.Trim(word, "':")
+				_, _, isDenomination := LookupDenomination(word)
+				if word == "" || fragmentBreakWords[word] || isDenomination || NEGOTIATION_WORDS[word] != "" || strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+					flush()
+					continue
+				}
+				run = append(run, word)
+			}
+			flush()
+		}
+	}
+
+	return fragments
+}
+
+// Counts the unmatched fragments of a listing and keeps the line as an
+// example of each, up to FRAGMENT_EXAMPLES_KEPT per fragment
+func RecordUnmatchedFragments(auction *Auction) {
+	for _, fragment := range auction.UnmatchedFragments() {
+		LogInDebugMode("Unmatched fragment: ", fragment)
+
+		query := "INSERT INTO unmatched_fragments (server, fragment, first_seen, last_seen) VALUES (?, ?, NOW(), NOW()) " +
+			"ON DUPLICATE KEY UPDATE seen = seen + 1, last_seen = NOW(), id = LAST_INSERT_ID(id)"
+		id, err := DB.Insert(query, auction.Server, fragment)
+		if err != nil || id <= 0 {
+			continue
+		}
+
+		exampleQuery := "INSERT INTO unmatched_fragment_examples (fragment_id, line) " +
+			"SELECT ?, ? FROM DUAL WHERE (SELECT kept FROM (SELECT COUNT(*) AS kept FROM unmatched_fragment_examples WHERE fragment_id = ?) AS examples) < ?"
+		if _, err := DB.Exec(exampleQuery, id, auction.Line(), id, FRAGMENT_EXAMPLES_KEPT); err != nil {
+			fmt.Println("Failed to keep an example for fragment " + fragment + ": ", err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"strings"
 	"fmt"
+	"unicode"
 )
 
 // MIGRATE THIS TO stringutil eventually
@@ -33,6 +34,27 @@ func TitleCase(name string, urlFriendly bool) string {
 	return uriString
 }
 
+// Given a name, generate a lower case slug with words separated by hyphens,
+// e.g. "Words of the Spoken" becomes "words-of-the-spoken".  Unlike TitleCase
+// this doesn't assume the wiki's URL scheme
+func Slugify(name string) string {
+	slug := ""
+	pendingHyphen := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingHyphen && len(slug) > 0 {
+				slug += "-"
+			}
+			slug += string(r)
+			pendingHyphen = false
+		} else if r != '\'' && r != '`' {
+			pendingHyphen = true
+		}
+	}
+
+	return slug
+}
+
 func ReplaceMultiple(src string, replaceWith string, matchWith ...string) string {
 	out := src
 	for _, word := range matchWith {
@@ -12,7 +12,39 @@ import (
 // Global connection to be used by the server
 var DB = Database{}
 
+// Bounded pool of workers which parse and persist uploaded auction lines
+var Workers = NewWorkerPool(WORKER_POOL_SIZE, WORKER_QUEUE_SIZE)
+
+// Cache used to skip lines and prices we have already seen, built from DEDUP_BACKEND
+var Dedup DedupCache
+
+// Durable queue of events waiting to go out to the relay and wiki services
+var Deliveries = NewOutbox()
+
+// Closes idle listings and records them as inferred sales
+var Sales = SaleDetector{}
+
+// Everything the auction stream is published to, built from PUBLISHERS
+var Publishers []Publisher
+
+// Rebuilds the catalog of items, aliases and name rules the parser matches against
+var CatalogRefresh = CatalogRefresher{}
+
+// Compares the shadow parser's reading of each line with the active parser's
+var Shadow = ShadowParser{}
+
+// Re-parses stored auction lines with the active parser, see reprocess.go
+var Reprocess = Reprocessor{}
+
+// Outbox destination for wiki item lookups, publishers use their own name
+const DESTINATION_WIKI = "wiki"
+
 func main() {
+	// Run a one off command (e.g. explain) instead of the server, see commands.go
+	if len(os.Args) > 1 {
+		os.Exit(RunCommand(os.Args[1], os.Args[2:]))
+	}
+
 	// Register the cleanup listener:
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -22,11 +54,48 @@ func main() {
 		os.Exit(1)
 	}()
 
+	// Refuse to start with a parser version that doesn't exist, see parsers.go
+	if err := CheckParsers(); err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialise DB connections
 	fmt.Println("Initialising database connection")
 	DB.Open()
 	fmt.Println("Connection initialised")
 
+	// Build the catalog lines are matched against before we parse anything
+	AC.RefreshCatalog()
+	CatalogRefresh.Start()
+
+	// One dedup cache client is shared by every request
+	var err error
+	Dedup, err = NewDedupCache(DEDUP_BACKEND)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Using dedup cache: " + Dedup.Name())
+
+	// Start the workers before we accept any uploads
+	fmt.Println("Starting " + fmt.Sprint(WORKER_POOL_SIZE) + " workers")
+	Workers.Start()
+
+	// Start delivering anything left in the outbox from a previous run
+	for _, name := range PUBLISHERS {
+		p, err := NewPublisher(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Publishing auctions to: " + p.Name())
+		Publishers = append(Publishers, p)
+		Deliveries.Register(p.Name(), p.Publish)
+	}
+	Deliveries.Register(DESTINATION_WIKI, AC.deliverToWikiService)
+	Deliveries.Start()
+
+	Sales.Start()
+	Reprocess.Start()
+
 	// Initialise router
 	fmt.Println("Starting webserver...")
 	fmt.Println("Listening on port: " + PORT)
@@ -37,6 +106,12 @@ func main() {
 func cleanup() {
 	fmt.Println("Beginning clean-up")
 
+	// Let the workers finish what is already queued before the DB goes away
+	Workers.Stop()
+	Deliveries.Stop()
+	Sales.Stop()
+	Reprocess.Stop()
+	CatalogRefresh.Stop()
 	DB.Close()
 
 	fmt.Println("Finished clean-up")
@@ -0,0 +1,19 @@
+-- Outgoing events waiting to be delivered to the relay and wiki services.
+-- Rows are written by the parser and removed by the outbox dispatcher once
+-- delivered, rows which run out of attempts are kept with a status of 'dead'.
+CREATE TABLE IF NOT EXISTS outbox (
+	id              BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	destination     VARCHAR(32)     NOT NULL,
+	topic           VARCHAR(255)    NOT NULL DEFAULT '',
+	payload         MEDIUMBLOB      NOT NULL,
+	status          ENUM('pending', 'dead') NOT NULL DEFAULT 'pending',
+	attempts        INT UNSIGNED    NOT NULL DEFAULT 0,
+	last_error      VARCHAR(512)    NULL,
+	next_attempt_at DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	claimed_by      VARCHAR(64)     NULL,
+	claimed_until   DATETIME        NULL,
+	created_at      DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY outbox_due (status, next_attempt_at),
+	KEY outbox_claim (claimed_by)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
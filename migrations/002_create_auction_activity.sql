@@ -0,0 +1,11 @@
+-- Unique auction sightings per seller per hour (log time).  A seller repeating
+-- the same auction counts each time, the same sighting uploaded by several
+-- clients only counts once.
+CREATE TABLE IF NOT EXISTS auction_activity (
+	server  VARCHAR(8)   NOT NULL,
+	seller  VARCHAR(64)  NOT NULL,
+	hour    DATETIME     NOT NULL,
+	adverts INT UNSIGNED NOT NULL DEFAULT 0,
+	PRIMARY KEY (server, seller, hour),
+	KEY auction_activity_hour (hour)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,20 @@
+-- History of sellers changing the price, quantity or buy/sell intent of an
+-- item they keep auctioning.  changed_at is the log timestamp of the auction
+-- which showed the change.
+CREATE TABLE IF NOT EXISTS listing_changes (
+	id           BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	player_id    BIGINT UNSIGNED NOT NULL,
+	item_id      BIGINT UNSIGNED NOT NULL,
+	server       VARCHAR(8)      NOT NULL,
+	old_price    FLOAT           NOT NULL,
+	new_price    FLOAT           NOT NULL,
+	old_quantity INT             NOT NULL,
+	new_quantity INT             NOT NULL,
+	old_selling  TINYINT(1)      NOT NULL,
+	new_selling  TINYINT(1)      NOT NULL,
+	changed_at   DATETIME        NOT NULL,
+	created_at   DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY listing_changes_item (server, item_id, changed_at),
+	KEY listing_changes_player (player_id, changed_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
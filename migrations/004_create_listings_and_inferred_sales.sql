@@ -0,0 +1,36 @@
+-- Open sell listings, one row per (server, seller, item) updated every time
+-- the item is seen in a unique auction line.  first_seen and last_seen are
+-- the log timestamps of the auctions (on our clock when the client sent its
+-- UTC offset), not the times we received them.
+CREATE TABLE IF NOT EXISTS listings (
+	id         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	server     VARCHAR(8)      NOT NULL,
+	player_id  BIGINT UNSIGNED NOT NULL,
+	item_id    BIGINT UNSIGNED NOT NULL,
+	price      FLOAT           NOT NULL,
+	quantity   INT             NOT NULL,
+	sightings  INT UNSIGNED    NOT NULL DEFAULT 1,
+	first_seen DATETIME        NOT NULL,
+	last_seen  DATETIME        NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY listings_listing (server, player_id, item_id),
+	KEY listings_last_seen (last_seen)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Listings which stopped being advertised after being advertised regularly,
+-- most likely because the item sold.  final_price is the last asking price.
+CREATE TABLE IF NOT EXISTS inferred_sales (
+	id              BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	server          VARCHAR(8)      NOT NULL,
+	player_id       BIGINT UNSIGNED NOT NULL,
+	item_id         BIGINT UNSIGNED NOT NULL,
+	final_price     FLOAT           NOT NULL,
+	quantity        INT             NOT NULL,
+	sightings       INT UNSIGNED    NOT NULL,
+	first_seen      DATETIME        NOT NULL,
+	last_seen       DATETIME        NOT NULL,
+	secs_on_market  INT UNSIGNED    NOT NULL,
+	created_at      DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY inferred_sales_item (server, item_id, last_seen)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,9 @@
+-- Store both the unit and lot price of every auction along with which one the
+-- seller actually wrote.  price is kept for existing readers and holds the
+-- unit price.
+ALTER TABLE auctions
+	ADD COLUMN unit_price  FLOAT                NULL AFTER price,
+	ADD COLUMN lot_price   FLOAT                NULL AFTER unit_price,
+	ADD COLUMN price_basis ENUM('unit', 'lot')  NULL AFTER lot_price;
+
+UPDATE auctions SET unit_price = price, lot_price = price * quantity WHERE unit_price IS NULL;
@@ -0,0 +1,12 @@
+-- Prices given as a range ("4-5k") keep both ends, price/unit_price hold the
+-- upper end.  negotiation holds any obo, firm, offers or pst flags and
+-- price_weight is how much the price should count towards price statistics
+-- (see PRICE_STATISTIC_WEIGHTS), 0 means leave it out.
+ALTER TABLE auctions
+	ADD COLUMN price_min    FLOAT                               NULL AFTER price_basis,
+	ADD COLUMN price_max    FLOAT                               NULL AFTER price_min,
+	ADD COLUMN negotiation  SET('obo', 'firm', 'offers', 'pst') NOT NULL DEFAULT '' AFTER price_max,
+	ADD COLUMN price_weight FLOAT                               NOT NULL DEFAULT 1 AFTER negotiation;
+
+UPDATE auctions SET price_min = unit_price, price_max = unit_price WHERE price_min IS NULL;
+UPDATE auctions SET price_weight = 0 WHERE price IS NULL OR price <= 0;
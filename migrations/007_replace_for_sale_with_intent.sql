@@ -0,0 +1,12 @@
+-- Auctions are now sell, buy or trade rather than for sale or not.  Trades
+-- ("WTT", "trading", "trade for") are kept out of both the buy and sell
+-- price series.
+ALTER TABLE auctions ADD COLUMN intent ENUM('sell', 'buy', 'trade') NOT NULL DEFAULT 'sell' AFTER for_sale;
+UPDATE auctions SET intent = IF(for_sale, 'sell', 'buy');
+ALTER TABLE auctions DROP COLUMN for_sale;
+
+ALTER TABLE listing_changes
+	ADD COLUMN old_intent ENUM('sell', 'buy', 'trade') NOT NULL DEFAULT 'sell' AFTER new_quantity,
+	ADD COLUMN new_intent ENUM('sell', 'buy', 'trade') NOT NULL DEFAULT 'sell' AFTER old_intent;
+UPDATE listing_changes SET old_intent = IF(old_selling, 'sell', 'buy'), new_intent = IF(new_selling, 'sell', 'buy');
+ALTER TABLE listing_changes DROP COLUMN old_selling, DROP COLUMN new_selling;
@@ -0,0 +1,10 @@
+-- Price checks ("PC Cloak of Flames?") per item per hour (log time), a demand
+-- signal kept apart from the listings in auctions.
+CREATE TABLE IF NOT EXISTS price_checks (
+	server  VARCHAR(8)      NOT NULL,
+	item_id BIGINT UNSIGNED NOT NULL,
+	hour    DATETIME        NOT NULL,
+	checks  INT UNSIGNED    NOT NULL DEFAULT 0,
+	PRIMARY KEY (server, item_id, hour),
+	KEY price_checks_hour (hour)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,4 @@
+-- Charges left on wands, rods and potions ("(5 charges)", "3/10"), NULL when
+-- the seller didn't say.  Price statistics for items with charges should be
+-- grouped by this column.
+ALTER TABLE auctions ADD COLUMN charges SMALLINT UNSIGNED NULL AFTER quantity;
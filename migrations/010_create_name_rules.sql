@@ -0,0 +1,10 @@
+-- Extra prefix/suffix rules on top of NAME_RULES, e.g. ('tome of ', '') or
+-- ('scroll: ', '').  Include any space between the rule and the name.
+CREATE TABLE IF NOT EXISTS name_rules (
+	id         INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	prefix     VARCHAR(64)  NOT NULL DEFAULT '',
+	suffix     VARCHAR(64)  NOT NULL DEFAULT '',
+	created_at DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE KEY name_rules_rule (prefix, suffix)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
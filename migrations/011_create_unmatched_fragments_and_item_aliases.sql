@@ -0,0 +1,35 @@
+-- Text from listings which didn't match an item, counted per server for a
+-- curator to review.  status is new until the fragment is promoted to an
+-- item or alias (resolution says which) or ignored.
+CREATE TABLE IF NOT EXISTS unmatched_fragments (
+	id         BIGINT UNSIGNED                       NOT NULL AUTO_INCREMENT,
+	server     VARCHAR(8)                            NOT NULL,
+	fragment   VARCHAR(255)                          NOT NULL,
+	seen       INT UNSIGNED                          NOT NULL DEFAULT 1,
+	first_seen DATETIME                              NOT NULL,
+	last_seen  DATETIME                              NOT NULL,
+	status     ENUM('new', 'promoted', 'ignored')    NOT NULL DEFAULT 'new',
+	resolution VARCHAR(255)                          NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY unmatched_fragments_fragment (server, fragment),
+	KEY unmatched_fragments_review (status, seen)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS unmatched_fragment_examples (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	fragment_id BIGINT UNSIGNED NOT NULL,
+	line        TEXT            NOT NULL,
+	created_at  DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY unmatched_fragment_examples_fragment (fragment_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Other names an item is auctioned under, matched by the parser as the item.
+CREATE TABLE IF NOT EXISTS item_aliases (
+	id         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	alias      VARCHAR(255)    NOT NULL,
+	item_id    BIGINT UNSIGNED NOT NULL,
+	created_at DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE KEY item_aliases_alias (alias)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
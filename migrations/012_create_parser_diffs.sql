@@ -0,0 +1,17 @@
+-- Fields the shadow parser read differently from the active parser, one row
+-- per field per line.  item is the position of the item in the line, field
+-- is "item" when one parser found an item the other didn't.
+CREATE TABLE IF NOT EXISTS parser_diffs (
+	id             BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	server         VARCHAR(8)      NOT NULL,
+	active_version VARCHAR(32)     NOT NULL,
+	shadow_version VARCHAR(32)     NOT NULL,
+	line           TEXT            NOT NULL,
+	item           INT UNSIGNED    NOT NULL,
+	field          VARCHAR(32)     NOT NULL,
+	active_value   VARCHAR(255)    NOT NULL,
+	shadow_value   VARCHAR(255)    NOT NULL,
+	created_at     DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY parser_diffs_versions (active_version, shadow_version, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,37 @@
+-- The parser version (see parsers.go) which produced each auction row, NULL
+-- for rows stored before versions were recorded.  created_at is when the row
+-- was stored, the rows of one sighting share it.  It is NULL for rows stored
+-- before it was recorded (the default only applies to new rows), the
+-- reprocessor treats each of those rows as a sighting of its own.
+ALTER TABLE auctions
+	ADD COLUMN parser_version VARCHAR(32) NULL AFTER raw_auction,
+	ADD COLUMN created_at     DATETIME    NULL AFTER parser_version;
+
+ALTER TABLE auctions
+	MODIFY COLUMN created_at DATETIME NULL DEFAULT CURRENT_TIMESTAMP,
+	ADD KEY auctions_parser_version (parser_version, created_at);
+
+-- Jobs which re-parse the raw_auction of stored rows, see reprocess.go.
+-- server, item and from_time/to_time narrow the rows, empty or NULL for all.
+-- last_id is the first row id of the last sighting processed so a job
+-- resumes where it stopped.
+CREATE TABLE IF NOT EXISTS reprocess_jobs (
+	id             BIGINT UNSIGNED                                           NOT NULL AUTO_INCREMENT,
+	status         ENUM('queued', 'running', 'done', 'failed', 'cancelled') NOT NULL DEFAULT 'queued',
+	server         VARCHAR(8)                                                NOT NULL DEFAULT '',
+	item           VARCHAR(255)                                              NOT NULL DEFAULT '',
+	from_time      DATETIME                                                  NULL,
+	to_time        DATETIME                                                  NULL,
+	parser_version VARCHAR(32)                                               NULL,
+	total          INT UNSIGNED                                              NOT NULL DEFAULT 0,
+	processed      INT UNSIGNED                                              NOT NULL DEFAULT 0,
+	replaced       INT UNSIGNED                                              NOT NULL DEFAULT 0,
+	skipped        INT UNSIGNED                                              NOT NULL DEFAULT 0,
+	last_id        BIGINT UNSIGNED                                           NOT NULL DEFAULT 0,
+	error          VARCHAR(255)                                              NULL,
+	created_at     DATETIME                                                  NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	started_at     DATETIME                                                  NULL,
+	finished_at    DATETIME                                                  NULL,
+	PRIMARY KEY (id),
+	KEY reprocess_jobs_status (status, id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
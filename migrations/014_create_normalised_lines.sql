@@ -0,0 +1,16 @@
+-- Uploaded lines whose text normalise.go had to rewrite (more than trimming
+-- the line ending), counted per server.  original holds the bytes exactly as
+-- the client sent them, fixes is what was changed.
+CREATE TABLE IF NOT EXISTS normalised_lines (
+	id            BIGINT UNSIGNED                                                      NOT NULL AUTO_INCREMENT,
+	server        VARCHAR(8)                                                           NOT NULL,
+	original_hash CHAR(16)                                                             NOT NULL,
+	original      VARBINARY(2048)                                                      NOT NULL,
+	normalised    TEXT                                                                 NOT NULL,
+	fixes         SET('encoding', 'line_ending', 'whitespace', 'apostrophe', 'control') NOT NULL DEFAULT '',
+	seen          INT UNSIGNED                                                         NOT NULL DEFAULT 1,
+	first_seen    DATETIME                                                             NOT NULL,
+	last_seen     DATETIME                                                             NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY normalised_lines_original (server, original_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,5 @@
+-- A seller changing the charges left on a wand or rod at the same price is a
+-- change to the listing too, NULL when the seller didn't say (see 009).
+ALTER TABLE listing_changes
+	ADD COLUMN old_charges SMALLINT UNSIGNED NULL AFTER new_quantity,
+	ADD COLUMN new_charges SMALLINT UNSIGNED NULL AFTER old_charges;
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: NameRule
+ |--------------------------------------------------------------------------
+ |
+ | Players leave the boilerplate off a lot of item names, "Yaulp" for
+ | "Spell: Yaulp" or "Frost" for "Rune of Frost".  A name rule adds a
+ | prefix and/or suffix to a bare name, when the bare name isn't an item
+ | but the name with the rule applied is, the item is recorded under the
+ | canonical name.
+ |
+ | Rules come from NAME_RULES in the config followed by the name_rules
+ | table, which is read every time the catalog is refreshed so new rules
+ | ("tome of ", "scroll: ") take effect without a deploy.  The catalog adds
+ | the bare name of every item a rule fits, rules are applied in order and
+ | the first one to claim a bare name wins.
+ |
+ | @member prefix (string): Text added in front of the bare name, including
+ |         any trailing space e.g. "spell: "
+ | @member suffix (string): Text added after the bare name, including any
+ |         leading space
+ |
+ */
+
+type NameRule struct {
+	Prefix string
+	Suffix string
+}
+
+type NameRules []NameRule
+
+func (r NameRule) Apply(name string) string {
+	return r.Prefix + name + r.Suffix
+}
+
+// The rules from NAME_RULES in the config
+func DefaultNameRules() NameRules {
+	var rules NameRules
+	for _, rule := range NAME_RULES {
+		rules = append(rules, NameRule{Prefix: strings.ToLower(rule[0]), Suffix: strings.ToLower(rule[1])})
+	}
+
+	return rules
+}
+
+// The config rules followed by every rule in the name_rules table
+func LoadNameRules() NameRules {
+	rules := DefaultNameRules()
+
+	rows := DB.Query("SELECT prefix, suffix FROM name_rules ORDER BY id ASC")
+	if rows == nil {
+		return rules
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var rule NameRule
+		if err := rows.Scan(&rule.Prefix, &rule.Suffix); err != nil {
+			fmt.Println("Scan error: ", err)
+			continue
+		}
+		rule.Prefix = strings.ToLower(rule.Prefix)
+		rule.Suffix = strings.ToLower(rule.Suffix)
+		if rule.Prefix != "" || rule.Suffix != "" {
+			rules = append(rules, rule)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Println("ROW ERROR: ", err.Error())
+	}
+
+	return rules
+}
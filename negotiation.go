@@ -0,0 +1,62 @@
+package main
+
+/*
+ |-------------------------------------------------------------------------
+ | Negotiation
+ |--------------------------------------------------------------------------
+ |
+ | Words a seller puts after a price to say how firm it is, e.g.
+ | "Cloak of Flames 5k obo" or "Ale 5p pst".  Each word maps to the flag
+ | we record against the item it follows.
+ |
+ | A flagged price (or a range such as "4-5k") isn't as good a guide to
+ | what an item sells for as a plain one, so every item is given a
+ | price_weight from PRICE_STATISTIC_WEIGHTS which price statistics should
+ | multiply in, 0 leaves the price out altogether.  Trades are always 0 as
+ | the price (if any) is only a guide to what the seller wants in return.
+ |
+ */
+
+const (
+	NEGOTIATION_OBO    = "obo"
+	NEGOTIATION_FIRM   = "firm"
+	NEGOTIATION_OFFERS = "offers"
+	NEGOTIATION_PST    = "pst"
+)
+
+// Key in PRICE_STATISTIC_WEIGHTS for a price given as a range
+const PRICE_WEIGHT_RANGE = "range"
+
+var NEGOTIATION_WORDS = map[string]string{
+	"obo":    NEGOTIATION_OBO,
+	"ono":    NEGOTIATION_OBO,
+	"firm":   NEGOTIATION_FIRM,
+	"offer":  NEGOTIATION_OFFERS,
+	"offers": NEGOTIATION_OFFERS,
+	"pst":    NEGOTIATION_PST,
+}
+
+// Works out how much an item's price should count towards price statistics
+func PriceWeightFor(item *Item) float32 {
+	if item.Price <= 0 || item.intent == INTENT_TRADE {
+		return 0
+	}
+
+	weight := float32(1)
+	if item.PriceMin != item.PriceMax {
+		weight *= priceStatisticWeight(PRICE_WEIGHT_RANGE)
+	}
+	for _, flag := range item.Negotiation {
+		weight *= priceStatisticWeight(flag)
+	}
+
+	return weight
+}
+
+func priceStatisticWeight(key string) float32 {
+	if weight, ok := PRICE_STATISTIC_WEIGHTS[key]; ok {
+		return weight
+	}
+
+	return 1
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Line normalisation
+ |--------------------------------------------------------------------------
+ |
+ | Uploaded lines are cleaned up before anything else reads them, the
+ | auction regexes (and the dedup hash) expect a single line of UTF-8 with
+ | straight apostrophes and single spaces.  Clients send:
+ |
+ |   - Windows-1252 bytes from player text ("Soandso’s" written as 0x92),
+ |     decoded to the character they stand for
+ |   - CRLF line endings, trimmed
+ |   - doubled spaces, tabs and non-breaking spaces, collapsed to one space
+ |   - backticks and curly or accented apostrophes, made straight
+ |   - other control characters, dropped
+ |
+ | Bytes which aren't valid UTF-8 would come out of the JSON decoder as
+ | U+FFFD, so EscapeInvalidUTF8 carries each of them through the decoder as
+ | a private use character (U+EF80 - U+EFFF, byte 0x80 - 0xFF) first.  A
+ | character the client sent from that range is escaped a byte at a time
+ | as well, so UnescapeInvalidUTF8 turns every escaped character in the
+ | decoded lines back into its byte and NormaliseLine has exactly the bytes
+ | the client sent.  It keeps them as the original line and lines whose
+ | text was changed by more than the line ending are counted in
+ | normalised_lines for auditing, whether or not they turn out to be
+ | auctions.
+ |
+ */
+
+const (
+	NORMALISE_ENCODING    = "encoding"
+	NORMALISE_LINE_ENDING = "line_ending"
+	NORMALISE_WHITESPACE  = "whitespace"
+	NORMALISE_APOSTROPHE  = "apostrophe"
+	NORMALISE_CONTROL     = "control"
+)
+
+// Where a byte which isn't valid UTF-8 is escaped to, see EscapeInvalidUTF8
+const escapedByteBase = 0xEF00
+
+// Windows-1252 bytes 0x80 - 0x9F, the rest of the high half matches Latin-1.
+// -1 is a byte Windows-1252 leaves undefined
+var windows1252 = [32]rune{
+	0x20AC, -1, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, -1, 0x017D, -1,
+	-1, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, -1, 0x017E, 0x0178,
+}
+
+// Characters used in place of an apostrophe
+var apostrophes = map[rune]bool{
+	'`': true, '´': true, '‘': true, '’': true, '‛': true, 'ʼ': true, '′': true,
+}
+
+type NormalisedLine struct {
+	Text     string
+	Original []byte
+	Fixes    []string
+}
+
+// Whether anything other than the line ending was changed
+func (n NormalisedLine) Rewritten() bool {
+	for _, fix := range n.Fixes {
+		if fix != NORMALISE_LINE_ENDING {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (n *NormalisedLine) fixed(fix string) {
+	for _, existing := range n.Fixes {
+		if existing == fix {
+			return
+		}
+	}
+	n.Fixes = append(n.Fixes, fix)
+}
+
+// Replaces each byte of the body which isn't part of a valid UTF-8 sequence,
+// or is part of a character in the escape range, with the private use
+// character UnescapeInvalidUTF8 reads back as that byte
+func EscapeInvalidUTF8(body []byte) []byte {
+	// 0xEE 0xBE and 0xEE 0xBF start the characters U+EF80 - U+EFFF
+	if utf8.Valid(body) && !bytes.Contains(body, []byte{0xEE, 0xBE}) && !bytes.Contains(body, []byte{0xEE, 0xBF}) {
+		return body
+	}
+
+	var out bytes.Buffer
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRune(body[i:])
+		if (r == utf8.RuneError && size == 1) || isEscapedByte(r) {
+			for _, b := range body[i:i+size] {
+				out.WriteRune(escapedByteBase + rune(b))
+			}
+		} else {
+			out.Write(body[i:i+size])
+		}
+		i += size
+	}
+
+	return out.Bytes()
+}
+
+func isEscapedByte(r rune) bool {
+	return r >= escapedByteBase + 0x80 && r <= escapedByteBase + 0xFF
+}
+
+// Swaps the characters escaped by EscapeInvalidUTF8 back for their bytes, in
+// a line taken out of the decoded body
+func UnescapeInvalidUTF8(line string) string {
+	escaped := false
+	for _, r := range line {
+		escaped = escaped || isEscapedByte(r)
+	}
+	if !escaped {
+		return line
+	}
+
+	// Bytes which weren't escaped (e.g. raw Windows-1252) are copied as they are
+	var out bytes.Buffer
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		if isEscapedByte(r) {
+			out.WriteByte(byte(r - escapedByteBase))
+		} else {
+			out.WriteString(line[i:i+size])
+		}
+		i += size
+	}
+
+	return out.String()
+}
+
+// Decodes, trims and collapses the line, see the top of this file.  The line
+// can hold raw Windows-1252 bytes, read from a log file or unescaped by
+// UnescapeInvalidUTF8
+func NormaliseLine(line string) NormalisedLine {
+	n := NormalisedLine{}
+	var original bytes.Buffer
+	var text strings.Builder
+
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			original.WriteByte(line[i])
+			r = decodeWindows1252(line[i])
+			n.fixed(NORMALISE_ENCODING)
+		case r >= 0x80 && r <= 0x9F:
+			// A Windows-1252 byte the client read as Latin-1
+			original.WriteString(line[i:i+size])
+			r = decodeWindows1252(byte(r))
+			n.fixed(NORMALISE_ENCODING)
+		default:
+			original.WriteString(line[i:i+size])
+		}
+		i += size
+
+		if apostrophes[r] {
+			r = '\''
+			n.fixed(NORMALISE_APOSTROPHE)
+		} else if r < 0 || (unicode.IsControl(r) && !unicode.IsSpace(r)) {
+			n.fixed(NORMALISE_CONTROL)
+			continue
+		}
+		text.WriteRune(r)
+	}
+	n.Original = original.Bytes()
+
+	trimmed := strings.TrimRight(text.String(), "\r\n")
+	if len(trimmed) != text.Len() {
+		n.fixed(NORMALISE_LINE_ENDING)
+	}
+	n.Text = strings.Join(strings.Fields(trimmed), " ")
+	if n.Text != trimmed {
+		n.fixed(NORMALISE_WHITESPACE)
+	}
+
+	return n
+}
+
+func decodeWindows1252(b byte) rune {
+	if b >= 0x80 && b <= 0x9F {
+		return windows1252[b - 0x80]
+	}
+
+	return rune(b)
+}
+
+// Counts a line whose text was rewritten, keeping the bytes the client sent
+func RecordNormalisedLine(server string, line NormalisedLine) {
+	if !line.Rewritten() {
+		return
+	}
+	LogInDebugMode("Normalised line (" + strings.Join(line.Fixes, ",") + "): ", fmt.Sprintf("%q", line.Original))
+
+	hash := fnv.New64a()
+	hash.Write(line.Original)
+	query := "INSERT INTO normalised_lines (server, original_hash, original, normalised, fixes, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, NOW(), NOW()) " +
+		"ON DUPLICATE KEY UPDATE seen = seen + 1, last_seen = NOW()"
+	if _, err := DB.Exec(query, server, fmt.Sprintf("%016x", hash.Sum64()), line.Original, line.Text, strings.Join(line.Fixes, ",")); err != nil {
+		fmt.Println("Failed to record a normalised line: ", err)
+	}
+}
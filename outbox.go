@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Outbox
+ |--------------------------------------------------------------------------
+ |
+ | Outgoing events (relay auctions, wiki item lookups) are written to the
+ | outbox table instead of being fired off directly.  A dispatcher polls
+ | the table, leases a batch of due events and hands each one to the
+ | Deliverer registered for its destination.  Failed deliveries are retried
+ | with exponential backoff until OUTBOX_MAX_ATTEMPTS is reached, after
+ | which the event is dead-lettered and left in the table for an operator.
+ |
+ | Each destination has its own circuit breaker so a dead relay doesn't
+ | stop wiki lookups going out (and vice versa).
+ |
+ | The lease (claimed_by / claimed_until) means several service replicas
+ | can run a dispatcher against the same table without double delivering.
+ | Events are delivered one at a time, so a batch stops
+ | OUTBOX_LEASE_MARGIN_IN_SECS before its lease expires and hands the rest
+ | back rather than deliver them once another replica could lease them.
+ |
+ */
+
+const (
+	OUTBOX_PENDING = "pending"
+	OUTBOX_DEAD    = "dead"
+)
+
+// Sends a single payload to a destination, returning an error means the
+// event will be retried
+type Deliverer func(topic string, payload []byte) error
+
+type OutboxEvent struct {
+	id          int64
+	Destination string
+	Topic       string
+	Payload     []byte
+	Attempts    int
+}
+
+// Pending and dead counts for a single destination
+type OutboxBacklog struct {
+	Destination      string `json:"destination"`
+	Pending          int64  `json:"pending"`
+	Dead             int64  `json:"dead"`
+	OldestPendingSec int64  `json:"oldestPendingSecs"`
+	Breaker          string `json:"breaker"`
+}
+
+type Outbox struct {
+	mu         sync.Mutex
+	deliverers map[string]Deliverer
+	breakers   map[string]*CircuitBreaker
+	owner      string
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func NewOutbox() *Outbox {
+	host, _ := os.Hostname()
+
+	return &Outbox{
+		deliverers: map[string]Deliverer{},
+		breakers:   map[string]*CircuitBreaker{},
+		owner:      host + ":" + fmt.Sprint(os.Getpid()),
+	}
+}
+
+// Registers the function used to deliver events for the given destination
+func (o *Outbox) Register(destination string, deliverer Deliverer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.deliverers[destination] = deliverer
+	o.breakers[destination] = NewCircuitBreaker(OUTBOX_BREAKER_THRESHOLD, time.Second*OUTBOX_BREAKER_COOLDOWN_IN_SECS)
+}
+
+// Records an event for delivery, the dispatcher will pick it up on its next poll
+func (o *Outbox) Enqueue(destination, topic string, payload []byte) error {
+	query := "INSERT INTO outbox (destination, topic, payload) VALUES (?, ?, ?)"
+	_, err := DB.Exec(query, destination, topic, payload)
+	if err != nil {
+		fmt.Println("Failed to write " + destination + " event to the outbox: ", err)
+	}
+
+	return err
+}
+
+func (o *Outbox) Start() {
+	o.stop = make(chan struct{})
+	o.done = make(chan struct{})
+
+	go func() {
+		defer close(o.done)
+
+		ticker := time.NewTicker(time.Second * OUTBOX_POLL_INTERVAL_IN_SECS)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-o.stop:
+				return
+			case <-ticker.C:
+				o.dispatch()
+			}
+		}
+	}()
+}
+
+func (o *Outbox) Stop() {
+	if o.stop == nil {
+		return
+	}
+	close(o.stop)
+	<-o.done
+}
+
+// Leases a batch of due events and attempts to deliver each of them
+func (o *Outbox) dispatch() {
+	deadline := time.Now().Add(time.Second * (OUTBOX_LEASE_IN_SECS - OUTBOX_LEASE_MARGIN_IN_SECS))
+	for _, event := range o.claim() {
+		// A slow destination ate the lease, the next poll leases these again
+		if time.Now().After(deadline) {
+			o.release(&event, 0)
+			continue
+		}
+
+		o.mu.Lock()
+		deliver, ok := o.deliverers[event.Destination]
+		breaker := o.breakers[event.Destination]
+		o.mu.Unlock()
+
+		if !ok {
+			o.fail(&event, "no deliverer registered for destination: " + event.Destination)
+			continue
+		}
+
+		// Push the event back until the breaker will let a trial through,
+		// this isn't counted as an attempt
+		if !breaker.Allow() {
+			o.release(&event, breaker.RetryIn())
+			continue
+		}
+
+		err := deliver(event.Topic, event.Payload)
+		if err != nil {
+			breaker.Failure()
+			o.fail(&event, err.Error())
+			continue
+		}
+
+		breaker.Success()
+		if _, err := DB.Exec("DELETE FROM outbox WHERE id = ?", event.id); err != nil {
+			fmt.Println("Delivered outbox event " + fmt.Sprint(event.id) + " but could not remove it: ", err)
+		}
+	}
+}
+
+// Takes a lease on up to OUTBOX_BATCH_SIZE due events and returns them,
+// expired leases (a dispatcher died mid-batch) are picked up again
+func (o *Outbox) claim() []OutboxEvent {
+	var events []OutboxEvent
+
+	claimQuery := "UPDATE outbox SET claimed_by = ?, claimed_until = DATE_ADD(NOW(), INTERVAL ? SECOND) " +
+		"WHERE status = ? AND next_attempt_at <= NOW() AND (claimed_until IS NULL OR claimed_until < NOW()) " +
+		"ORDER BY id ASC LIMIT ?"
+	claimed, err := DB.Exec(claimQuery, o.owner, OUTBOX_LEASE_IN_SECS, OUTBOX_PENDING, OUTBOX_BATCH_SIZE)
+	if err != nil || claimed <= 0 {
+		return events
+	}
+
+	rows := DB.Query("SELECT id, destination, topic, payload, attempts FROM outbox WHERE claimed_by = ? AND status = ? ORDER BY id ASC", o.owner, OUTBOX_PENDING)
+	if rows == nil {
+		return events
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var e OutboxEvent
+		if err := rows.Scan(&e.id, &e.Destination, &e.Topic, &e.Payload, &e.Attempts); err != nil {
+			fmt.Println("Scan error: ", err)
+			continue
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Println("ROW ERROR: ", err.Error())
+	}
+
+	return events
+}
+
+// Records a failed attempt, scheduling a retry or dead-lettering the event
+// once it has run out of attempts
+func (o *Outbox) fail(event *OutboxEvent, reason string) {
+	attempts := event.Attempts + 1
+	if len(reason) > 512 {
+		reason = reason[0:512]
+	}
+
+	if attempts >= OUTBOX_MAX_ATTEMPTS {
+		fmt.Println("Dead-lettering outbox event " + fmt.Sprint(event.id) + " for " + event.Destination + ": " + reason)
+		DB.Exec("UPDATE outbox SET status = ?, attempts = ?, last_error = ?, claimed_by = NULL, claimed_until = NULL WHERE id = ?",
+			OUTBOX_DEAD, attempts, reason, event.id)
+		return
+	}
+
+	delay := backoff(attempts)
+	LogInDebugMode("Outbox event " + fmt.Sprint(event.id) + " failed, retrying in: ", delay)
+	DB.Exec("UPDATE outbox SET attempts = ?, last_error = ?, next_attempt_at = DATE_ADD(NOW(), INTERVAL ? SECOND), claimed_by = NULL, claimed_until = NULL WHERE id = ?",
+		attempts, reason, int64(delay / time.Second), event.id)
+}
+
+// Hands the event back without counting an attempt
+func (o *Outbox) release(event *OutboxEvent, after time.Duration) {
+	DB.Exec("UPDATE outbox SET next_attempt_at = DATE_ADD(NOW(), INTERVAL ? SECOND), claimed_by = NULL, claimed_until = NULL WHERE id = ?",
+		int64(math.Ceil(after.Seconds())), event.id)
+}
+
+// Moves dead events for a destination back to pending so they are retried
+// from scratch, returns the number of events requeued
+func (o *Outbox) Requeue(destination string) (int64, error) {
+	return DB.Exec("UPDATE outbox SET status = ?, attempts = 0, next_attempt_at = NOW() WHERE status = ? AND destination = ?",
+		OUTBOX_PENDING, OUTBOX_DEAD, destination)
+}
+
+// Returns the pending and dead-lettered counts for every destination
+func (o *Outbox) Backlog() []OutboxBacklog {
+	backlog := map[string]*OutboxBacklog{}
+
+	o.mu.Lock()
+	for destination, breaker := range o.breakers {
+		backlog[destination] = &OutboxBacklog{Destination: destination, Breaker: breaker.State()}
+	}
+	o.mu.Unlock()
+
+	query := "SELECT destination, status, COUNT(*), COALESCE(TIMESTAMPDIFF(SECOND, MIN(created_at), NOW()), 0) " +
+		"FROM outbox GROUP BY destination, status"
+	rows := DB.Query(query)
+	if rows != nil {
+		for rows.Next() {
+			var destination, status string
+			var count, oldest int64
+			if err := rows.Scan(&destination, &status, &count, &oldest); err != nil {
+				fmt.Println("Scan error: ", err)
+				continue
+			}
+
+			b, ok := backlog[destination]
+			if !ok {
+				b = &OutboxBacklog{Destination: destination, Breaker: BREAKER_CLOSED}
+				backlog[destination] = b
+			}
+			if strings.EqualFold(status, OUTBOX_DEAD) {
+				b.Dead = count
+			} else {
+				b.Pending = count
+				b.OldestPendingSec = oldest
+			}
+		}
+		DB.CloseRows(rows)
+	}
+
+	var out []OutboxBacklog
+	for _, b := range backlog {
+		out = append(out, *b)
+	}
+	return out
+}
+
+// Exponential backoff with a little jitter so a burst of failures doesn't
+// all retry on the same tick
+func backoff(attempts int) time.Duration {
+	delay := float64(OUTBOX_BACKOFF_BASE_IN_SECS) * math.Pow(2, float64(attempts-1))
+	if delay > OUTBOX_BACKOFF_MAX_IN_SECS {
+		delay = OUTBOX_BACKOFF_MAX_IN_SECS
+	}
+	delay += delay * 0.2 * rand.Float64()
+
+	return time.Duration(delay * float64(time.Second))
+}
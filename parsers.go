@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Parser versions
+ |--------------------------------------------------------------------------
+ |
+ | Each version of the item extractor is registered in PARSERS under a
+ | name.  ACTIVE_PARSER is the version whose items are stored, published
+ | and counted, SHADOW_PARSER (when set) is a candidate run alongside it on
+ | every listing and price check with only the differences kept, see
+ | shadow.go.
+ |
+ | To change how items are extracted, register the changed extractor under
+ | a new version, point SHADOW_PARSER at it and check the corpus and the
+ | differences at /admin/parser/shadow before making it ACTIVE_PARSER.
+ |
+ */
+
+// Fills in auction.Items from auction.itemLine
+type ItemParser func(c *AuctionController, auction *Auction)
+
+var PARSERS = map[string]ItemParser{
+	"1": (*AuctionController).extractItems,
+}
+
+// Returns the parser registered under the version
+func LookupParser(version string) (ItemParser, error) {
+	parser, ok := PARSERS[version]
+	if !ok {
+		return nil, errors.New("Unknown parser version: " + version)
+	}
+
+	return parser, nil
+}
+
+// Checks ACTIVE_PARSER and SHADOW_PARSER name registered parsers
+func CheckParsers() error {
+	if _, err := LookupParser(ACTIVE_PARSER); err != nil {
+		return err
+	}
+	if SHADOW_PARSER != "" {
+		if _, err := LookupParser(SHADOW_PARSER); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+// Reads every corpus line against the corpus catalog with each registered
+// parser version, nothing is deduplicated or saved so this measures the
+// classifier and the matcher on their own.  Run with
+//
+//   go test -run NONE -bench ParseLine
+func BenchmarkParseLine(b *testing.B) {
+	entries, err := LoadCorpus(CORPUS_FILE)
+	if err != nil {
+		b.Fatal(err)
+	}
+	catalog, err := LoadCorpusCatalog(CORPUS_ITEMS_FILE, CORPUS_ALIASES_FILE)
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := new(AuctionController)
+	c.SetCatalog(catalog)
+
+	for version := range PARSERS {
+		b.Run("parser-" + version, func(b *testing.B) {
+			lines := 0
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, entry := range entries {
+					if _, err := c.readLine(entry.Line, "BLUE", version, nil); err != nil {
+						b.Fatal(err)
+					}
+					lines++
+				}
+			}
+			b.ReportMetric(float64(lines) / b.Elapsed().Seconds(), "lines/sec")
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: WorkerPool
+ |--------------------------------------------------------------------------
+ |
+ | A fixed number of workers reading from a bounded queue.  Uploads are
+ | queued here instead of each spawning their own goroutines, when the
+ | queue is full TrySubmit returns false so the caller can shed the load
+ | rather than letting memory grow without limit.
+ |
+ | @member queue (chan Job): Buffered channel holding the pending jobs
+ | @member size (int): Number of workers reading from the queue
+ | @member busy (int32): Number of workers currently running a job
+ | @member stopped (bool): Set by Stop, jobs submitted after it are rejected
+ |
+ */
+
+type Job func()
+
+type WorkerPool struct {
+	queue     chan Job
+	size      int
+	busy      int32
+	processed uint64
+	rejected  uint64
+	wg        sync.WaitGroup
+	lock      sync.RWMutex
+	stopped   bool
+}
+
+// Snapshot of the pool returned by the stats endpoint
+type PoolStats struct {
+	Workers       int     `json:"workers"`
+	Busy          int     `json:"busy"`
+	Utilisation   float64 `json:"utilisation"`
+	QueueDepth    int     `json:"queueDepth"`
+	QueueCapacity int     `json:"queueCapacity"`
+	Processed     uint64  `json:"processed"`
+	Rejected      uint64  `json:"rejected"`
+}
+
+func NewWorkerPool(size, queueSize int) *WorkerPool {
+	if size < 1 {
+		size = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	return &WorkerPool{queue: make(chan Job, queueSize), size: size}
+}
+
+func (p *WorkerPool) Start() {
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+}
+
+// Closes the queue and waits for the workers to drain whatever is left in it.
+// Uploads still arriving while we shut down are rejected rather than sent on
+// the closed queue
+func (p *WorkerPool) Stop() {
+	p.lock.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.queue)
+	}
+	p.lock.Unlock()
+
+	p.wg.Wait()
+}
+
+// Attempts to queue the job without blocking, returns false if the queue
+// is full (or the pool has stopped) and the job was rejected
+func (p *WorkerPool) TrySubmit(job Job) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.stopped {
+		atomic.AddUint64(&p.rejected, 1)
+		return false
+	}
+
+	select {
+	case p.queue <- job:
+		return true
+	default:
+		atomic.AddUint64(&p.rejected, 1)
+		return false
+	}
+}
+
+func (p *WorkerPool) Stats() PoolStats {
+	busy := int(atomic.LoadInt32(&p.busy))
+
+	return PoolStats{
+		Workers:       p.size,
+		Busy:          busy,
+		Utilisation:   float64(busy) / float64(p.size),
+		QueueDepth:    len(p.queue),
+		QueueCapacity: cap(p.queue),
+		Processed:     atomic.LoadUint64(&p.processed),
+		Rejected:      atomic.LoadUint64(&p.rejected),
+	}
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+
+	for job := range p.queue {
+		p.run(job)
+	}
+}
+
+// Runs a single job, a panic in one job is logged rather than taking the
+// worker (and the rest of the queue) down with it
+func (p *WorkerPool) run(job Job) {
+	atomic.AddInt32(&p.busy, 1)
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("Recovered from panic in worker: ", r, string(debug.Stack()))
+		}
+		atomic.AddInt32(&p.busy, -1)
+		atomic.AddUint64(&p.processed, 1)
+	}()
+
+	job()
+}
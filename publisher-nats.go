@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"github.com/nats-io/nats.go"
+)
+
+// Publishes auctions to a NATS subject, the connection is opened on first
+// use and the client library takes care of reconnecting after that
+type NATSPublisher struct {
+	url  string
+	mu   sync.Mutex
+	conn *nats.Conn
+}
+
+func NewNATSPublisher(url string) *NATSPublisher {
+	return &NATSPublisher{url: url}
+}
+
+func (p *NATSPublisher) Name() string {
+	return PUBLISHER_NATS
+}
+
+func (p *NATSPublisher) DefaultTopic(server string) string {
+	return "auctions." + strings.ToLower(server)
+}
+
+func (p *NATSPublisher) Publish(topic string, payload []byte) error {
+	conn, err := p.connection()
+	if err != nil {
+		return err
+	}
+
+	if err := conn.Publish(topic, payload); err != nil {
+		return err
+	}
+
+	// Publish only buffers the message, flush so a failure is reported here
+	// and the outbox knows to retry it
+	return conn.FlushTimeout(time.Second * 5)
+}
+
+func (p *NATSPublisher) connection() (*nats.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn != nil && !p.conn.IsClosed() {
+		return p.conn, nil
+	}
+
+	conn, err := nats.Connect(p.url, nats.Name("eqdata-collection"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	p.conn = conn
+
+	return p.conn, nil
+}
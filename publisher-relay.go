@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Publishes auctions to the relay server which streams them to the web front
+// end via socket.io, topics are appended to the /auctions/ path
+type RelayPublisher struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewRelayPublisher(host, port string) *RelayPublisher {
+	return &RelayPublisher{
+		baseURL: "http://" + host + ":" + port + "/auctions/",
+		client:  &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (p *RelayPublisher) Name() string {
+	return PUBLISHER_RELAY
+}
+
+func (p *RelayPublisher) DefaultTopic(server string) string {
+	return strings.ToLower(server)
+}
+
+func (p *RelayPublisher) Publish(topic string, payload []byte) error {
+	req, err := http.NewRequest("POST", p.baseURL + topic, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return errors.New("relay service responded with: " + resp.Status)
+	}
+
+	return nil
+}
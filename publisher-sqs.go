@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Publishes auctions to an SQS queue using the SendMessage query API, the
+// topic is the full queue URL.  Because it only speaks plain HTTP it works
+// against any SQS-compatible endpoint such as a local ElasticMQ, requests are
+// only signed when an access key is configured.
+type SQSPublisher struct {
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func NewSQSPublisher(region, accessKey, secretKey string) *SQSPublisher {
+	return &SQSPublisher{
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (p *SQSPublisher) Name() string {
+	return PUBLISHER_SQS
+}
+
+// SQS queues have to be configured in PUBLISHER_TOPICS, there is no sensible
+// default so NewPublisher refuses to start without one for every server
+func (p *SQSPublisher) DefaultTopic(server string) string {
+	return ""
+}
+
+func (p *SQSPublisher) Publish(topic string, payload []byte) error {
+	if topic == "" {
+		return errors.New("no SQS queue URL configured for this server")
+	}
+
+	queue, err := url.Parse(topic)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("Action", "SendMessage")
+	form.Set("Version", "2012-11-05")
+	form.Set("MessageBody", string(payload))
+	body := form.Encode()
+
+	req, err := http.NewRequest("POST", queue.String(), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if p.accessKey != "" {
+		p.sign(req, queue, body, time.Now().UTC())
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return errors.New("SQS responded with: " + resp.Status + " " + string(respBody))
+	}
+
+	return nil
+}
+
+// Adds an AWS Signature Version 4 Authorization header to the request
+func (p *SQSPublisher) sign(req *http.Request, queue *url.URL, body string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + p.region + "/sqs/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	path := queue.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	signedHeaders := "content-type;host;x-amz-date"
+	canonicalRequest := "POST\n" +
+		path + "\n" +
+		"\n" +
+		"content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + queue.Host + "\n" +
+		"x-amz-date:" + amzDate + "\n" +
+		"\n" +
+		signedHeaders + "\n" +
+		hashHex([]byte(body))
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4" + p.secretKey), date)
+	key = hmacSHA256(key, p.region)
+	key = hmacSHA256(key, "sqs")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=" + p.accessKey + "/" + scope +
+		", SignedHeaders=" + signedHeaders + ", Signature=" + signature)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
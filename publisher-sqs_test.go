@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// The SigV4 signature worked out again from the request as the server
+// received it, the way SQS (and ElasticMQ with credentials) checks it
+func expectedSQSSignature(t *testing.T, r *http.Request, body []byte, secretKey, region string, signedHeaders []string) string {
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		t.Fatalf("X-Amz-Date is %q", amzDate)
+	}
+	date := amzDate[:8]
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	sum := func(data []byte) string {
+		s := sha256.Sum256(data)
+		return hex.EncodeToString(s[:])
+	}
+
+	canonical := r.Method + "\n" + r.URL.EscapedPath() + "\n" + r.URL.RawQuery + "\n"
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonical += name + ":" + strings.TrimSpace(value) + "\n"
+	}
+	canonical += "\n" + strings.Join(signedHeaders, ";") + "\n" + sum(body)
+
+	scope := date + "/" + region + "/sqs/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sum([]byte(canonical))
+
+	key := mac([]byte("AWS4" + secretKey), date)
+	key = mac(key, region)
+	key = mac(key, "sqs")
+	key = mac(key, "aws4_request")
+
+	return hex.EncodeToString(mac(key, toSign))
+}
+
+func TestSQSPublisherSignsSendMessage(t *testing.T) {
+	authorization := regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/(\d{8})/eu-west-2/sqs/aws4_request, SignedHeaders=([a-z0-9;-]+), Signature=([0-9a-f]{64})$`)
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if r.Method != "POST" || r.URL.Path != "/000000000000/auctions-blue" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			t.Errorf("Content-Type is %q", r.Header.Get("Content-Type"))
+		}
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			t.Fatalf("body isn't a form: %s", err)
+		}
+		if form.Get("Action") != "SendMessage" || form.Get("Version") != "2012-11-05" {
+			t.Errorf("Action/Version are %q/%q", form.Get("Action"), form.Get("Version"))
+		}
+		if form.Get("MessageBody") != `{"seller":"Soandso","line":"WTS Ale 5p & Bone Chips"}` {
+			t.Errorf("MessageBody is %q", form.Get("MessageBody"))
+		}
+
+		matches := authorization.FindStringSubmatch(r.Header.Get("Authorization"))
+		if matches == nil {
+			t.Fatalf("Authorization is %q", r.Header.Get("Authorization"))
+		}
+		if matches[1] != r.Header.Get("X-Amz-Date")[:8] {
+			t.Errorf("credential date %s doesn't match X-Amz-Date %s", matches[1], r.Header.Get("X-Amz-Date"))
+		}
+		signedHeaders := strings.Split(matches[2], ";")
+		for _, required := range []string{"content-type", "host", "x-amz-date"} {
+			found := false
+			for _, name := range signedHeaders {
+				found = found || name == required
+			}
+			if !found {
+				t.Errorf("%s isn't signed: %s", required, matches[2])
+			}
+		}
+		if expected := expectedSQSSignature(t, r, body, "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "eu-west-2", signedHeaders); matches[3] != expected {
+			t.Errorf("signature is %s, expected %s", matches[3], expected)
+		}
+
+		w.Write([]byte("<SendMessageResponse></SendMessageResponse>"))
+	}))
+	defer server.Close()
+
+	p := NewSQSPublisher("eu-west-2", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	if err := p.Publish(server.URL + "/000000000000/auctions-blue", []byte(`{"seller":"Soandso","line":"WTS Ale 5p & Bone Chips"}`)); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+	if requests != 1 {
+		t.Fatalf("expected 1 request, got %d", requests)
+	}
+}
+
+func TestSQSPublisherWithoutCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || r.Header.Get("X-Amz-Date") != "" {
+			t.Errorf("unsigned request carried Authorization %q", r.Header.Get("Authorization"))
+		}
+		w.Write([]byte("<SendMessageResponse></SendMessageResponse>"))
+	}))
+	defer server.Close()
+
+	p := NewSQSPublisher("elasticmq", "", "")
+	if err := p.Publish(server.URL + "/queue/auctions-red", []byte("{}")); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+}
+
+func TestSQSPublisherReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<ErrorResponse><Code>AWS.SimpleQueueService.NonExistentQueue</Code></ErrorResponse>", 400)
+	}))
+	defer server.Close()
+
+	p := NewSQSPublisher("elasticmq", "", "")
+	err := p.Publish(server.URL + "/queue/missing", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "NonExistentQueue") {
+		t.Fatalf("expected the SQS error to be returned, got %v", err)
+	}
+	if err := p.Publish("", []byte("{}")); err == nil {
+		t.Fatalf("expected an error without a queue URL")
+	}
+}
+
+func TestSQSPublisherNeedsAQueueForEveryServer(t *testing.T) {
+	configured := PUBLISHER_TOPICS[PUBLISHER_SQS]
+	defer func() { PUBLISHER_TOPICS[PUBLISHER_SQS] = configured }()
+
+	PUBLISHER_TOPICS[PUBLISHER_SQS] = map[string]string{"BLUE": "http://localhost:9324/queue/auctions-blue"}
+	if _, err := NewPublisher(PUBLISHER_SQS); err == nil || !strings.Contains(err.Error(), "RED") {
+		t.Fatalf("expected the missing RED queue to be reported, got %v", err)
+	}
+
+	PUBLISHER_TOPICS[PUBLISHER_SQS] = map[string]string{"RED": "http://localhost:9324/queue/auctions-red", "BLUE": "http://localhost:9324/queue/auctions-blue"}
+	if _, err := NewPublisher(PUBLISHER_SQS); err != nil {
+		t.Fatalf("expected the publisher to be built, got %s", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Publisher
+ |--------------------------------------------------------------------------
+ |
+ | Anything the auction stream can be published to.  Every publisher named
+ | in PUBLISHERS is registered as an outbox destination so publishing goes
+ | through the same retry, circuit breaker and dead-letter handling.
+ |
+ | The topic means something different to each implementation: the path
+ | segment for the relay, the queue URL for SQS and the subject for NATS.
+ | Topics are configured per server in PUBLISHER_TOPICS.
+ |
+ */
+
+type Publisher interface {
+	Name() string
+	DefaultTopic(server string) string
+	Publish(topic string, payload []byte) error
+}
+
+const PUBLISHER_RELAY = "relay"
+const PUBLISHER_SQS = "sqs"
+const PUBLISHER_NATS = "nats"
+
+// The servers auctions are uploaded (and so published) for
+var PUBLISHED_SERVERS = []string{"RED", "BLUE"}
+
+// Builds the publisher with the given name from config, every server needs a
+// topic (SQS has no default) or its events could only ever be dead-lettered
+func NewPublisher(name string) (Publisher, error) {
+	var p Publisher
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case PUBLISHER_RELAY:
+		p = NewRelayPublisher(RELAY_SERVICE_HOST, RELAY_SERVICE_PORT)
+	case PUBLISHER_SQS:
+		p = NewSQSPublisher(SQS_REGION, SQS_ACCESS_KEY, SQS_SECRET_KEY)
+	case PUBLISHER_NATS:
+		p = NewNATSPublisher(NATS_URL)
+	default:
+		return nil, errors.New("Unknown publisher: " + name)
+	}
+
+	for _, server := range PUBLISHED_SERVERS {
+		if TopicFor(p, server) == "" {
+			return nil, errors.New("No " + p.Name() + " topic configured for " + server + ", add it to PUBLISHER_TOPICS")
+		}
+	}
+
+	return p, nil
+}
+
+// Returns the topic the given publisher should use for a server, falling back
+// to the publishers default when the server isn't configured
+func TopicFor(p Publisher, server string) string {
+	if topics, ok := PUBLISHER_TOPICS[p.Name()]; ok {
+		if topic, ok := topics[strings.ToUpper(server)]; ok && topic != "" {
+			return topic
+		}
+	}
+
+	return p.DefaultTopic(server)
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Reprocessor
+ |--------------------------------------------------------------------------
+ |
+ | Re-parses the raw_auction stored with each auctions row so parser
+ | improvements reach the history, e.g. rows stored as "Spell: Yaulp" for
+ | lines which were really selling "Spell: Yaulp IV".  Every row is stamped
+ | with the parser_version that produced it, a job replaces the rows which
+ | weren't produced by ACTIVE_PARSER (as it was when the job started).
+ |
+ | Jobs are queued through POST /admin/reprocess, optionally narrowed to a
+ | server, an item (lines which produced a row for it) and a from/to range
+ | of created_at, and picked up in turn by the reprocessor.  The rows of a
+ | single sighting (a line stored at one time by one seller) are replaced
+ | together in a transaction keeping their created_at.  Rows stored before
+ | created_at was recorded are a sighting each, and aren't in a from/to
+ | range.
+ |
+ | A sighting never gains rows.  The live stream only stores the items of a
+ | line which weren't recently auctioned by the seller, so an item the
+ | parser reads which was stored keeps its row, a row whose item the parser
+ | no longer reads goes to the next item it reads instead ("Spell: Yaulp"
+ | becoming "Spell: Yaulp IV") and the rest of the items aren't stored.  A
+ | sighting the parser finds none of its items in is left as it is and
+ | counted as skipped.  Replaced rows carry the job's parser version so
+ | running a job again, or resuming it after a restart, never replaces a
+ | row twice.
+ |
+ | Progress (sightings processed, replaced and skipped out of the total) is
+ | saved after every batch of REPROCESS_BATCH_SIZE sightings and shown by
+ | GET /admin/reprocess.  Only the auctions rows are replaced, listings,
+ | listing changes and inferred sales follow the live stream.
+ |
+ */
+
+const (
+	REPROCESS_QUEUED    = "queued"
+	REPROCESS_RUNNING   = "running"
+	REPROCESS_DONE      = "done"
+	REPROCESS_FAILED    = "failed"
+	REPROCESS_CANCELLED = "cancelled"
+)
+
+// Matches the raw_auction stored with each auctions row, "Soandso auctions, '...'"
+var storedLineRegex = regexp.MustCompile(`^([A-Za-z]+) auctions?, '(.*)'$`)
+
+// The auctions columns which pick out a sighting, a row without created_at is
+// a sighting on its own
+const sightingColumns = "server, player_id, raw_auction, created_at, IF(created_at IS NULL, id, 0)"
+
+type Reprocessor struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+type ReprocessJob struct {
+	Id            int64  `json:"id"`
+	Status        string `json:"status"`
+	Server        string `json:"server"`
+	Item          string `json:"item"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	ParserVersion string `json:"parserVersion"`
+	Total         int64  `json:"total"`
+	Processed     int64  `json:"processed"`
+	Replaced      int64  `json:"replaced"`
+	Skipped       int64  `json:"skipped"`
+	Error         string `json:"error"`
+	CreatedAt     string `json:"createdAt"`
+	StartedAt     string `json:"startedAt"`
+	FinishedAt    string `json:"finishedAt"`
+	lastId        int64  // the first row id of the last sighting processed
+}
+
+// The rows of one line stored at one time by one seller
+type storedSighting struct {
+	firstId   int64
+	server    string
+	playerId  int64
+	raw       string
+	createdAt string // empty for a row stored before created_at was recorded
+}
+
+func (r *Reprocessor) Start() {
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(time.Second * REPROCESS_POLL_INTERVAL_IN_SECS)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				if job, ok := r.claim(); ok {
+					r.run(job)
+				}
+			}
+		}
+	}()
+}
+
+func (r *Reprocessor) Stop() {
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+}
+
+// Queues a job to reprocess the rows matching its server, item and range
+func (r *Reprocessor) Queue(job ReprocessJob) (ReprocessJob, error) {
+	if job.Item != "" {
+		rows := DB.Query("SELECT displayName FROM items WHERE displayName = ?", job.Item)
+		if rows == nil {
+			return job, errors.New("Could not look up the item")
+		}
+		found := rows.Next()
+		if found {
+			rows.Scan(&job.Item)
+		}
+		DB.CloseRows(rows)
+		if !found {
+			return job, errors.New("There is no item called: " + job.Item)
+		}
+	}
+
+	query := "INSERT INTO reprocess_jobs (server, item, from_time, to_time) VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''))"
+	id, err := DB.Insert(query, job.Server, job.Item, job.From, job.To)
+	if err != nil || id <= 0 {
+		return job, errors.New("Failed to queue the job")
+	}
+
+	return r.Job(id)
+}
+
+// Loads a job along with its progress
+func (r *Reprocessor) Job(id int64) (ReprocessJob, error) {
+	jobs := r.jobs("WHERE id = ?", id)
+	if len(jobs) == 0 {
+		return ReprocessJob{}, errors.New("There is no reprocess job " + fmt.Sprint(id))
+	}
+
+	return jobs[0], nil
+}
+
+// The most recently queued jobs, newest first
+func (r *Reprocessor) Jobs(limit int) []ReprocessJob {
+	return r.jobs("ORDER BY id DESC LIMIT ?", limit)
+}
+
+func (r *Reprocessor) jobs(clause string, params ...interface{}) []ReprocessJob {
+	jobs := []ReprocessJob{}
+	query := "SELECT id, status, server, item, COALESCE(DATE_FORMAT(from_time, '%Y-%m-%d %H:%i:%s'), ''), COALESCE(DATE_FORMAT(to_time, '%Y-%m-%d %H:%i:%s'), ''), " +
+		"COALESCE(parser_version, ''), total, processed, replaced, skipped, COALESCE(error, ''), DATE_FORMAT(created_at, '%Y-%m-%d %H:%i:%s'), " +
+		"COALESCE(DATE_FORMAT(started_at, '%Y-%m-%d %H:%i:%s'), ''), COALESCE(DATE_FORMAT(finished_at, '%Y-%m-%d %H:%i:%s'), ''), last_id " +
+		"FROM reprocess_jobs " + clause
+	rows := DB.Query(query, params...)
+	if rows == nil {
+		return jobs
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var j ReprocessJob
+		err := rows.Scan(&j.Id, &j.Status, &j.Server, &j.Item, &j.From, &j.To, &j.ParserVersion, &j.Total, &j.Processed,
+			&j.Replaced, &j.Skipped, &j.Error, &j.CreatedAt, &j.StartedAt, &j.FinishedAt, &j.lastId)
+		if err != nil {
+			fmt.Println("Scan error: ", err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs
+}
+
+// Stops a queued or running job, a running job stops after its current batch
+func (r *Reprocessor) Cancel(id int64) (bool, error) {
+	cancelled, err := DB.Exec("UPDATE reprocess_jobs SET status = ?, finished_at = NOW() WHERE id = ? AND status IN (?, ?)",
+		REPROCESS_CANCELLED, id, REPROCESS_QUEUED, REPROCESS_RUNNING)
+	return cancelled == 1, err
+}
+
+// Takes the oldest queued job, only the replica whose update wins runs it.
+// A job resumed after a restart keeps the parser version it started with
+func (r *Reprocessor) claim() (ReprocessJob, bool) {
+	rows := DB.Query("SELECT id FROM reprocess_jobs WHERE status = ? ORDER BY id ASC LIMIT 1", REPROCESS_QUEUED)
+	if rows == nil {
+		return ReprocessJob{}, false
+	}
+	var id int64
+	if rows.Next() {
+		rows.Scan(&id)
+	}
+	DB.CloseRows(rows)
+	if id <= 0 {
+		return ReprocessJob{}, false
+	}
+
+	query := "UPDATE reprocess_jobs SET status = ?, parser_version = COALESCE(parser_version, ?), started_at = COALESCE(started_at, NOW()) " +
+		"WHERE id = ? AND status = ?"
+	claimed, err := DB.Exec(query, REPROCESS_RUNNING, ACTIVE_PARSER, id, REPROCESS_QUEUED)
+	if err != nil || claimed != 1 {
+		return ReprocessJob{}, false
+	}
+
+	job, err := r.Job(id)
+	return job, err == nil
+}
+
+// Works through the job a batch at a time until it is done, cancelled or
+// the reprocessor is stopped, in which case it is queued again to resume
+func (r *Reprocessor) run(job ReprocessJob) {
+	parser, err := LookupParser(job.ParserVersion)
+	if err != nil {
+		r.finish(&job, REPROCESS_FAILED, err.Error())
+		return
+	}
+
+	if job.lastId == 0 {
+		where, params := job.where()
+		rows := DB.Query("SELECT COUNT(*) FROM (SELECT 1 FROM auctions WHERE " + where + " GROUP BY " + sightingColumns + ") AS sightings", params...)
+		if rows != nil {
+			if rows.Next() {
+				rows.Scan(&job.Total)
+			}
+			DB.CloseRows(rows)
+		}
+	}
+	fmt.Println("Reprocessing job " + fmt.Sprint(job.Id) + " with parser " + job.ParserVersion + ", " + fmt.Sprint(job.Total - job.Processed) + " sightings to go")
+
+	for {
+		select {
+		case <-r.stop:
+			DB.Exec("UPDATE reprocess_jobs SET status = ? WHERE id = ? AND status = ?", REPROCESS_QUEUED, job.Id, REPROCESS_RUNNING)
+			return
+		default:
+		}
+
+		sightings := job.nextBatch()
+		if len(sightings) == 0 {
+			r.finish(&job, REPROCESS_DONE, "")
+			return
+		}
+
+		for _, sighting := range sightings {
+			if r.replace(job, sighting, parser) {
+				job.Replaced++
+			} else {
+				job.Skipped++
+			}
+			job.Processed++
+			job.lastId = sighting.firstId
+		}
+
+		// Nothing is saved once the job has been cancelled, so stop
+		query := "UPDATE reprocess_jobs SET total = ?, processed = ?, replaced = ?, skipped = ?, last_id = ? WHERE id = ? AND status = ?"
+		saved, err := DB.Exec(query, job.Total, job.Processed, job.Replaced, job.Skipped, job.lastId, job.Id, REPROCESS_RUNNING)
+		if err != nil || saved != 1 {
+			fmt.Println("Stopped reprocessing job " + fmt.Sprint(job.Id) + " after " + fmt.Sprint(job.Processed) + " sightings")
+			return
+		}
+	}
+}
+
+func (r *Reprocessor) finish(job *ReprocessJob, status, reason string) {
+	query := "UPDATE reprocess_jobs SET status = ?, error = NULLIF(?, ''), total = ?, processed = ?, replaced = ?, skipped = ?, last_id = ?, finished_at = NOW() " +
+		"WHERE id = ? AND status = ?"
+	DB.Exec(query, status, reason, job.Total, job.Processed, job.Replaced, job.Skipped, job.lastId, job.Id, REPROCESS_RUNNING)
+	fmt.Println("Reprocess job " + fmt.Sprint(job.Id) + " " + status + ": " + fmt.Sprint(job.Replaced) + " replaced, " + fmt.Sprint(job.Skipped) + " skipped " + reason)
+}
+
+// The conditions a row has to meet to be reprocessed by the job
+func (j *ReprocessJob) where() (string, []interface{}) {
+	where := "(parser_version IS NULL OR parser_version <> ?)"
+	params := []interface{}{j.ParserVersion}
+	if j.Server != "" {
+		where += " AND server = ?"
+		params = append(params, j.Server)
+	}
+	if j.From != "" {
+		where += " AND created_at >= ?"
+		params = append(params, j.From)
+	}
+	if j.To != "" {
+		where += " AND created_at < ?"
+		params = append(params, j.To)
+	}
+	if j.Item != "" {
+		where += " AND item_id IN (SELECT id FROM items WHERE displayName = ?)"
+		params = append(params, j.Item)
+	}
+
+	return where, params
+}
+
+// The next sightings after the last one processed, in the order they were stored
+func (j *ReprocessJob) nextBatch() []storedSighting {
+	where, params := j.where()
+	query := "SELECT MIN(id), server, player_id, raw_auction, COALESCE(DATE_FORMAT(created_at, '%Y-%m-%d %H:%i:%s'), '') FROM auctions WHERE " + where +
+		" GROUP BY " + sightingColumns + " HAVING MIN(id) > ? ORDER BY MIN(id) ASC LIMIT ?"
+	params = append(params, j.lastId, REPROCESS_BATCH_SIZE)
+
+	var sightings []storedSighting
+	rows := DB.Query(query, params...)
+	if rows == nil {
+		return sightings
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var s storedSighting
+		if err := rows.Scan(&s.firstId, &s.server, &s.playerId, &s.raw, &s.createdAt); err != nil {
+			fmt.Println("Scan error: ", err)
+			continue
+		}
+		sightings = append(sightings, s)
+	}
+
+	return sightings
+}
+
+// Re-parses the sighting's line and swaps its rows for the rows the parser
+// makes of it now, false if it was left as it was
+func (r *Reprocessor) replace(job ReprocessJob, sighting storedSighting, parser ItemParser) bool {
+	auction, ok := AC.readStoredLine(sighting.raw, sighting.server, parser)
+	if !ok || auction.label != LINE_LISTING {
+		return false
+	}
+	auction.lookupItemIds()
+
+	replaced := false
+	err := DB.Transaction(func(tx *sql.Tx) error {
+		ids, itemIds, err := sighting.rows(tx)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		items := replacementItems(itemIds, auction.Items)
+		if len(items) == 0 {
+			return nil
+		}
+
+		// The same row as a live insert with the sighting's created_at on the end
+		row := strings.TrimSuffix(AUCTION_ROW, "),") + ", NULLIF(?, '')),"
+		query := "INSERT INTO auctions (" + AUCTION_COLUMNS + ", created_at) VALUES "
+		var params []interface{}
+		for _, item := range items {
+			query += row
+			params = append(params, auction.auctionRow(item, sighting.playerId, job.ParserVersion)...)
+			params = append(params, sighting.createdAt)
+		}
+		query = query[0:len(query)-1]
+
+		deleteQuery := "DELETE FROM auctions WHERE id IN (?" + strings.Repeat(", ?", len(ids) - 1) + ")"
+		var deleteParams []interface{}
+		for _, id := range ids {
+			deleteParams = append(deleteParams, id)
+		}
+		if _, err := tx.Exec(deleteQuery, deleteParams...); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(query, params...); err != nil {
+			return err
+		}
+
+		replaced = true
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Failed to replace the rows of: " + sighting.raw + ": ", err)
+		return false
+	}
+
+	return replaced
+}
+
+// The ids and item ids of the sighting's rows, locked until the transaction ends
+func (s storedSighting) rows(tx *sql.Tx) ([]int64, []int64, error) {
+	query := "SELECT id, item_id FROM auctions WHERE id = ? FOR UPDATE"
+	params := []interface{}{s.firstId}
+	if s.createdAt != "" {
+		query = "SELECT id, item_id FROM auctions WHERE server = ? AND player_id = ? AND raw_auction = ? AND created_at = ? ORDER BY id FOR UPDATE"
+		params = []interface{}{s.server, s.playerId, s.raw, s.createdAt}
+	}
+
+	rows, err := tx.Query(query, params...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids, itemIds []int64
+	for rows.Next() {
+		var id, itemId int64
+		if err := rows.Scan(&id, &itemId); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		itemIds = append(itemIds, itemId)
+	}
+
+	return ids, itemIds, rows.Err()
+}
+
+// Picks the items which replace the rows stored with the item ids given, see
+// the top of this file.  An item stored before keeps its row, the rows left
+// over go to the other items in the order they were read
+func replacementItems(storedItemIds []int64, items []Item) []Item {
+	stored := map[int64]int{}
+	for _, id := range storedItemIds {
+		stored[id]++
+	}
+
+	kept := make([]bool, len(items))
+	for i, item := range items {
+		if item.id > 0 && stored[item.id] > 0 {
+			stored[item.id]--
+			kept[i] = true
+		}
+	}
+
+	spare := 0
+	for _, count := range stored {
+		spare += count
+	}
+	var replacements []Item
+	for i, item := range items {
+		if !kept[i] && item.id > 0 && spare > 0 {
+			spare--
+			kept[i] = true
+		}
+		if kept[i] {
+			replacements = append(replacements, item)
+		}
+	}
+
+	return replacements
+}
+
+// Parses a raw_auction as stored in the auctions table with the parser given
+func (c *AuctionController) readStoredLine(raw, server string, parser ItemParser) (Auction, bool) {
+	matches := storedLineRegex.FindStringSubmatch(NormaliseLine(raw).Text)
+	if len(matches) == 0 {
+		return Auction{}, false
+	}
+
+	auction := Auction{Server: server, Seller: matches[1], itemLine: matches[2], raw: raw}
+	auction.label = ClassifyLine(auction.itemLine)
+	parser(c, &auction)
+
+	return auction, true
+}
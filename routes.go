@@ -19,4 +19,88 @@ var routes = Routes {
 		"/channels/auction",
 		AC.store,
 	},
+	Route {
+		"Auction Event Schema",
+		"GET",
+		"/schema/auctions",
+		AC.schema,
+	},
+	Route {
+		"Explain Auction Line",
+		"POST",
+		"/debug/parse",
+		AC.explain,
+	},
+	Route {
+		"Unmatched Fragments",
+		"GET",
+		"/catalog/fragments",
+		CC.fragments,
+	},
+	Route {
+		"Promote Fragment",
+		"POST",
+		"/catalog/fragments/{id}/promote",
+		RequireAdmin(CC.promoteFragment),
+	},
+	Route {
+		"Ignore Fragment",
+		"POST",
+		"/catalog/fragments/{id}/ignore",
+		RequireAdmin(CC.ignoreFragment),
+	},
+	Route {
+		"Health",
+		"GET",
+		"/health",
+		ADC.health,
+	},
+	Route {
+		"Worker Pool Stats",
+		"GET",
+		"/admin/pool",
+		ADC.poolStats,
+	},
+	Route {
+		"Outbox Backlog",
+		"GET",
+		"/admin/outbox",
+		ADC.outboxBacklog,
+	},
+	Route {
+		"Shadow Parser Diffs",
+		"GET",
+		"/admin/parser/shadow",
+		ADC.shadowParserDiffs,
+	},
+	Route {
+		"Reprocess Jobs",
+		"GET",
+		"/admin/reprocess",
+		ADC.reprocessJobs,
+	},
+	Route {
+		"Queue Reprocess Job",
+		"POST",
+		"/admin/reprocess",
+		RequireAdmin(ADC.queueReprocessJob),
+	},
+	Route {
+		"Reprocess Job",
+		"GET",
+		"/admin/reprocess/{id}",
+		ADC.reprocessJob,
+	},
+	Route {
+		"Cancel Reprocess Job",
+		"POST",
+		"/admin/reprocess/{id}/cancel",
+		RequireAdmin(ADC.cancelReprocessJob),
+	},
+	Route {
+		"Requeue Dead Deliveries",
+		"POST",
+		"/admin/outbox/{destination}/requeue",
+		RequireAdmin(ADC.requeueDeadDeliveries),
+	},
 }
\ No newline at end of file
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: SaleDetector
+ |--------------------------------------------------------------------------
+ |
+ | Infers sales from listings disappearing.  Every unique sighting of an
+ | item a seller is selling updates their row in the listings table, this
+ | detector periodically closes listings which haven't been seen for
+ | SALE_IDLE_IN_SECS.  A listing that was advertised at least
+ | SALE_MIN_SIGHTINGS times is recorded in inferred_sales as "probably
+ | sold" at its last asking price along with how long it was on the market,
+ | anything advertised less than that is just dropped.
+ |
+ | first_seen and last_seen are the log times of the sightings (moved onto
+ | our clock when the client sends its UTC offset, see
+ | RawAuctions.ClockOffset) rather than when they were uploaded, so
+ | secs_on_market is how long the item was really advertised for.  last_seen
+ | only moves forward, so a late upload of an old log doesn't keep a listing
+ | open.
+ |
+ | Closing deletes the listing and records the sale in one transaction, and
+ | only records it if the delete won, so several replicas can run the
+ | detector at once.
+ |
+ */
+
+type SaleDetector struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+type openListing struct {
+	id           int64
+	server       string
+	playerId     int64
+	itemId       int64
+	price        float32
+	quantity     int32
+	sightings    int64
+	firstSeen    string
+	lastSeen     string
+	secsOnMarket int64
+}
+
+// Records a sighting of every item the auction is selling at the auction's
+// log time, a sighting older than the last one counts but doesn't change the
+// asking price.  MySQL applies the assignments in order, so the price and
+// quantity are compared against last_seen before it moves
+func TrackListings(auction *Auction) {
+	if auction.playerId <= 0 {
+		return
+	}
+
+	query := "INSERT INTO listings (server, player_id, item_id, price, quantity, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE price = IF(VALUES(last_seen) >= last_seen, VALUES(price), price), " +
+		"quantity = IF(VALUES(last_seen) >= last_seen, VALUES(quantity), quantity), " +
+		"first_seen = LEAST(first_seen, VALUES(first_seen)), last_seen = GREATEST(last_seen, VALUES(last_seen)), sightings = sightings + 1"
+	seen := auction.Timestamp.Format("2006-01-02 15:04:05")
+	for _, item := range auction.Items {
+		if item.id <= 0 || item.intent != INTENT_SELL {
+			continue
+		}
+
+		quantity := item.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+		DB.Exec(query, auction.Server, auction.playerId, item.id, item.Price, quantity, seen, seen)
+	}
+}
+
+func (d *SaleDetector) Start() {
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(time.Second * SALE_DETECTOR_INTERVAL_IN_SECS)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.closeIdleListings()
+			}
+		}
+	}()
+}
+
+func (d *SaleDetector) Stop() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+}
+
+// A listing another replica closed first
+var errListingGone = errors.New("listing was already closed")
+
+// last_seen is a log time on our clock, so it is compared with NOW() to find
+// the listings which haven't been advertised for SALE_IDLE_IN_SECS
+func (d *SaleDetector) closeIdleListings() {
+	query := "SELECT id, server, player_id, item_id, price, quantity, sightings, " +
+		"DATE_FORMAT(first_seen, '%Y-%m-%d %H:%i:%s'), DATE_FORMAT(last_seen, '%Y-%m-%d %H:%i:%s'), TIMESTAMPDIFF(SECOND, first_seen, last_seen) " +
+		"FROM listings WHERE last_seen < DATE_SUB(NOW(), INTERVAL ? SECOND) ORDER BY id ASC LIMIT ?"
+	rows := DB.Query(query, SALE_IDLE_IN_SECS, SALE_DETECTOR_BATCH_SIZE)
+	if rows == nil {
+		return
+	}
+
+	var idle []openListing
+	for rows.Next() {
+		var l openListing
+		if err := rows.Scan(&l.id, &l.server, &l.playerId, &l.itemId, &l.price, &l.quantity, &l.sightings, &l.firstSeen, &l.lastSeen, &l.secsOnMarket); err != nil {
+			fmt.Println("Scan error: ", err)
+			continue
+		}
+		idle = append(idle, l)
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Println("ROW ERROR: ", err.Error())
+	}
+	DB.CloseRows(rows)
+
+	sold := 0
+	for _, l := range idle {
+		// Only the replica whose delete wins records the sale, if the insert
+		// fails the delete is rolled back and the listing is closed next time
+		recorded := false
+		err := DB.Transaction(func(tx *sql.Tx) error {
+			result, err := tx.Exec("DELETE FROM listings WHERE id = ? AND last_seen < DATE_SUB(NOW(), INTERVAL ? SECOND)", l.id, SALE_IDLE_IN_SECS)
+			if err != nil {
+				return err
+			}
+			if deleted, err := result.RowsAffected(); err != nil || deleted != 1 {
+				return errListingGone
+			}
+			if l.sightings < SALE_MIN_SIGHTINGS {
+				return nil
+			}
+
+			_, err = tx.Exec("INSERT INTO inferred_sales (server, player_id, item_id, final_price, quantity, sightings, first_seen, last_seen, secs_on_market) "+
+				"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				l.server, l.playerId, l.itemId, l.price, l.quantity, l.sightings, l.firstSeen, l.lastSeen, l.secsOnMarket)
+			recorded = err == nil
+			return err
+		})
+		if err != nil && err != errListingGone {
+			fmt.Println("Failed to close listing " + fmt.Sprint(l.id) + ": ", err)
+		}
+		if err == nil && recorded {
+			sold++
+		}
+	}
+
+	if len(idle) > 0 {
+		fmt.Println("Closed " + fmt.Sprint(len(idle)) + " idle listings, " + fmt.Sprint(sold) + " inferred as sold")
+	}
+}
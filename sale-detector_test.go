@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+// A database/sql driver which records every statement executed against it
+// instead of running it
+type recordedExec struct {
+	query string
+	args  []driver.Value
+}
+
+type recordingConnector struct{ execs *[]recordedExec }
+type recordingConn struct{ execs *[]recordedExec }
+type recordingStmt struct {
+	execs *[]recordedExec
+	query string
+}
+
+func (c recordingConnector) Connect(context.Context) (driver.Conn, error) { return recordingConn{c.execs}, nil }
+func (c recordingConnector) Driver() driver.Driver                       { return c }
+func (c recordingConnector) Open(string) (driver.Conn, error)            { return recordingConn{c.execs}, nil }
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) { return recordingStmt{c.execs, query}, nil }
+func (c recordingConn) Close() error                              { return nil }
+func (c recordingConn) Begin() (driver.Tx, error)                 { return nil, errors.New("transactions aren't recorded") }
+
+func (s recordingStmt) Close() error  { return nil }
+func (s recordingStmt) NumInput() int { return -1 }
+func (s recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	*s.execs = append(*s.execs, recordedExec{s.query, args})
+	return driver.RowsAffected(1), nil
+}
+func (s recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries aren't recorded")
+}
+
+// Points DB at a recording driver until the test ends
+func recordStatements(t *testing.T) *[]recordedExec {
+	execs := &[]recordedExec{}
+	configured := DB
+	DB = Database{conn: sql.OpenDB(recordingConnector{execs})}
+	t.Cleanup(func() {
+		DB.conn.Close()
+		DB = configured
+	})
+
+	return execs
+}
+
+// The listing's last_seen only moves forward (GREATEST in TrackListings), so
+// an old log uploaded late has to be tracked at the time it was logged
+func TestOldLogUploadLeavesLastSeenUnchanged(t *testing.T) {
+	execs := recordStatements(t)
+	live, late := oldLogUpload(t, "")
+
+	for _, upload := range []RawAuctions{live, late} {
+		auction := stampedLine(t, upload)
+		auction.playerId = 1
+		auction.Items = []Item{{Name: "ale", id: 7, Price: 5, Quantity: 1, intent: INTENT_SELL}}
+		TrackListings(&auction)
+	}
+
+	if len(*execs) != 2 {
+		t.Fatalf("expected a listing update per upload, got %d", len(*execs))
+	}
+	for i, exec := range *execs {
+		firstSeen, lastSeen := exec.args[5], exec.args[6]
+		if firstSeen != "2026-10-19 12:00:00" || lastSeen != "2026-10-19 12:00:00" {
+			t.Errorf("upload %d tracked the listing as seen %v - %v, it was logged at 2026-10-19 12:00:00", i, firstSeen, lastSeen)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ShadowParser
+ |--------------------------------------------------------------------------
+ |
+ | Runs the SHADOW_PARSER version over every listing and price check the
+ | ACTIVE_PARSER has just parsed and records each field they disagree on
+ | (an item missing or extra, a name, price, quantity, intent...) in
+ | parser_diffs along with the line.  The shadow's items are thrown away,
+ | only the active parser's are stored or published.
+ |
+ | The number of lines compared and the number which differed are kept in
+ | memory since the service started, the diffs themselves are summarised
+ | per field by /admin/parser/shadow.
+ |
+ */
+
+type ShadowParser struct {
+	lock     sync.Mutex
+	compared int64
+	differed int64
+	since    time.Time
+}
+
+type ParserDiff struct {
+	Server      string `json:"server"`
+	Line        string `json:"line"`
+	Item        int    `json:"item"`
+	Field       string `json:"field"`
+	ActiveValue string `json:"activeValue"`
+	ShadowValue string `json:"shadowValue"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+type ShadowFieldSummary struct {
+	Field string `json:"field"`
+	Diffs int64  `json:"diffs"`
+	Lines int64  `json:"lines"`
+}
+
+type ShadowSummary struct {
+	Enabled      bool                 `json:"enabled"`
+	ActiveParser string               `json:"activeParser"`
+	ShadowParser string               `json:"shadowParser"`
+	Since        string               `json:"since"`
+	Compared     int64                `json:"compared"`
+	Differed     int64                `json:"differed"`
+	Fields       []ShadowFieldSummary `json:"fields"`
+	Recent       []ParserDiff         `json:"recent"`
+}
+
+func (s *ShadowParser) Enabled() bool {
+	return SHADOW_PARSER != "" && SHADOW_PARSER != ACTIVE_PARSER
+}
+
+// Parses a copy of the auction with the shadow parser and records where it
+// differs from the items the active parser found.  A candidate parser which
+// panics is logged rather than taking the line down with it
+func (s *ShadowParser) Compare(c *AuctionController, active *Auction) {
+	parser, err := LookupParser(SHADOW_PARSER)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("Shadow parser " + SHADOW_PARSER + " panicked on: " + active.itemLine + ": ", r)
+		}
+	}()
+
+	shadow := Auction{
+		Seller: active.Seller,
+		Timestamp: active.Timestamp,
+		Server: active.Server,
+		Zone: active.Zone,
+		label: active.label,
+		itemLine: active.itemLine,
+		raw: active.raw,
+	}
+	parser(c, &shadow)
+
+	var expected []CorpusItem
+	for _, item := range active.Items {
+		expected = append(expected, NewCorpusItem(item))
+	}
+	diffs := DiffItems(expected, shadow.Items)
+
+	s.lock.Lock()
+	if s.since.IsZero() {
+		s.since = time.Now()
+	}
+	s.compared++
+	if len(diffs) > 0 {
+		s.differed++
+	}
+	s.lock.Unlock()
+
+	for _, diff := range diffs {
+		LogInDebugMode("Shadow parser differs: ", diff.String())
+
+		field := diff.Field
+		if field == "" {
+			field = "item"
+		}
+		query := "INSERT INTO parser_diffs (server, active_version, shadow_version, line, item, field, active_value, shadow_value) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+		if _, err := DB.Exec(query, active.Server, ACTIVE_PARSER, SHADOW_PARSER, active.Line(), diff.Item, field, diff.Expected, diff.Got); err != nil {
+			fmt.Println("Failed to record a parser diff: ", err)
+		}
+	}
+}
+
+// Summarises the diffs between the current active and shadow versions over
+// the last number of hours, with up to limit of the most recent diffs
+func (s *ShadowParser) Summary(hours, limit int) ShadowSummary {
+	summary := ShadowSummary{
+		Enabled: s.Enabled(),
+		ActiveParser: ACTIVE_PARSER,
+		ShadowParser: SHADOW_PARSER,
+		Fields: []ShadowFieldSummary{},
+		Recent: []ParserDiff{},
+	}
+
+	s.lock.Lock()
+	summary.Compared = s.compared
+	summary.Differed = s.differed
+	if !s.since.IsZero() {
+		summary.Since = s.since.Format("2006-01-02 15:04:05")
+	}
+	s.lock.Unlock()
+
+	query := "SELECT field, COUNT(*), COUNT(DISTINCT line) FROM parser_diffs " +
+		"WHERE active_version = ? AND shadow_version = ? AND created_at >= NOW() - INTERVAL ? HOUR GROUP BY field ORDER BY COUNT(*) DESC"
+	rows := DB.Query(query, ACTIVE_PARSER, SHADOW_PARSER, hours)
+	if rows != nil {
+		for rows.Next() {
+			var f ShadowFieldSummary
+			if err := rows.Scan(&f.Field, &f.Diffs, &f.Lines); err != nil {
+				fmt.Println("Scan error: ", err)
+				continue
+			}
+			summary.Fields = append(summary.Fields, f)
+		}
+		DB.CloseRows(rows)
+	}
+
+	query = "SELECT server, line, item, field, active_value, shadow_value, DATE_FORMAT(created_at, '%Y-%m-%d %H:%i:%s') FROM parser_diffs " +
+		"WHERE active_version = ? AND shadow_version = ? AND created_at >= NOW() - INTERVAL ? HOUR ORDER BY id DESC LIMIT ?"
+	rows = DB.Query(query, ACTIVE_PARSER, SHADOW_PARSER, hours, limit)
+	if rows != nil {
+		for rows.Next() {
+			var d ParserDiff
+			if err := rows.Scan(&d.Server, &d.Line, &d.Item, &d.Field, &d.ActiveValue, &d.ShadowValue, &d.CreatedAt); err != nil {
+				fmt.Println("Scan error: ", err)
+				continue
+			}
+			summary.Recent = append(summary.Recent, d)
+		}
+		DB.CloseRows(rows)
+	}
+
+	return summary
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: AuctionEvent
+ |--------------------------------------------------------------------------
+ |
+ | The payload published for every parsed auction.  Each publisher is sent
+ | the schema version configured for it in PUBLISHER_SCHEMA_VERSIONS so
+ | consumers can move to a new version when they are ready, GET
+ | /schema/auctions lists the supported versions.
+ |
+ | Version 1 is the original relay payload, with the segments (see below)
+ | added so the front end can link items without rendering the raw line:
+ |   { "Lines": [{ "line": "Seller auctions, '...'", "items": [{ "name", "uri" }],
+ |                 "segments": [...] }] }
+ |
+ | Version 2 is this struct:
+ | @member version (int): Always 2
+ | @member id (string): Stable id for the auction, derived from the server,
+ |         seller, log timestamp and line so retries and other collectors
+ |         produce the same id
+ | @member server (string): RED or BLUE
+ | @member zone (string): Zone the uploading client was in
+ | @member seller (string): Name of the player auctioning
+ | @member timestamp (time.Time): Timestamp from the log line
+ | @member receivedAt (time.Time): When this service parsed the line
+ | @member line (string): Seller auctions, '...' as seen in the log
+ | @member confidence (float32): 0-1 estimate of how much of the line the
+ |         parser understood, see Auction.ParseConfidence
+ | @member items ([]AuctionEventItem): Every item found in the line
+ | @member segments ([]AuctionSegment): The line split into plain text and
+ |         item references in order, front ends should render these rather
+ |         than the raw line so player text is never treated as markup
+ | @member changes ([]ListingChanged): Listings whose price, quantity or
+ |         intent changed since the seller last auctioned them
+ |
+*/
+
+const AUCTION_EVENT_VERSION = 2
+
+var SUPPORTED_AUCTION_EVENT_VERSIONS = []int{1, 2}
+
+type AuctionEvent struct {
+	Version    int                `json:"version"`
+	Id         string             `json:"id"`
+	Server     string             `json:"server"`
+	Zone       string             `json:"zone"`
+	Seller     string             `json:"seller"`
+	Timestamp  time.Time          `json:"timestamp"`
+	ReceivedAt time.Time          `json:"receivedAt"`
+	Line       string             `json:"line"`
+	Confidence float32            `json:"confidence"`
+	Items      []AuctionEventItem `json:"items"`
+	Segments   []AuctionSegment   `json:"segments"`
+	Changes    []ListingChanged   `json:"changes"`
+}
+
+// A single item within an AuctionEvent, id is 0 when the item isn't in our
+// items table yet.  Prices are in platinum and 0 when no price was given,
+// price is the unit price, lotPrice the price of the whole quantity and
+// priceBasis says which of the two the seller actually wrote.  priceMin and
+// priceMax are the ends of a unit price given as a range (both equal price
+// otherwise) and negotiation lists any obo, firm, offers or pst flags.
+// charges is null unless the seller said how many charges the item has left,
+// intent is "sell", "buy" or "trade", selling is kept for consumers which
+// only know about selling and buying and is true when intent is "sell".  The
+// slug is a lower case, hyphenated form of the name which doesn't assume any
+// particular site's URL scheme, uri is the wiki page name
+type AuctionEventItem struct {
+	Id          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Slug        string   `json:"slug"`
+	Uri         string   `json:"uri"`
+	Price       float32  `json:"price"`
+	LotPrice    float32  `json:"lotPrice"`
+	PriceBasis  string   `json:"priceBasis"`
+	PriceMin    float32  `json:"priceMin"`
+	PriceMax    float32  `json:"priceMax"`
+	Negotiation []string `json:"negotiation"`
+	Quantity    int16    `json:"quantity"`
+	Charges     *int16   `json:"charges"`
+	Intent      string   `json:"intent"`
+	Selling     bool     `json:"selling"`
+}
+
+// The version 1 payload, kept for consumers which haven't moved on yet
+type legacyAuctionPayload struct {
+	Lines []legacyAuctionLine `json:"Lines"`
+}
+
+type legacyAuctionLine struct {
+	Line     string              `json:"line"`
+	Items    []legacyAuctionItem `json:"items"`
+	Segments []AuctionSegment    `json:"segments"`
+}
+
+type legacyAuctionItem struct {
+	Name string `json:"name"`
+	Uri  string `json:"uri"`
+}
+
+func NewAuctionEvent(a Auction) AuctionEvent {
+	event := AuctionEvent{
+		Version:    AUCTION_EVENT_VERSION,
+		Id:         a.EventId(),
+		Server:     a.Server,
+		Zone:       a.Zone,
+		Seller:     a.Seller,
+		Timestamp:  a.Timestamp,
+		ReceivedAt: time.Now().UTC(),
+		Line:       a.Line(),
+		Confidence: a.ParseConfidence(),
+		Items:      []AuctionEventItem{},
+		Segments:   a.Segments(),
+		Changes:    a.Changes,
+	}
+	if event.Changes == nil {
+		event.Changes = []ListingChanged{}
+	}
+
+	for _, item := range a.Items {
+		event.Items = append(event.Items, newAuctionEventItem(item))
+	}
+
+	return event
+}
+
+func newAuctionEventItem(item Item) AuctionEventItem {
+	name := strings.TrimSpace(item.Name)
+	if item.displayName != "" {
+		name = item.displayName
+	}
+	quantity := item.Quantity
+	if quantity == 0 {
+		quantity = 1
+	}
+	var charges *int16
+	if item.Charges > 0 {
+		charges = &item.Charges
+	}
+	negotiation := item.Negotiation
+	if negotiation == nil {
+		negotiation = []string{}
+	}
+
+	return AuctionEventItem{
+		Id:          item.id,
+		Name:        TitleCase(name, false),
+		Slug:        Slugify(name),
+		Uri:         TitleCase(name, true),
+		Price:       item.Price,
+		LotPrice:    item.LotPrice,
+		PriceBasis:  item.PriceBasis,
+		PriceMin:    item.PriceMin,
+		PriceMax:    item.PriceMax,
+		Negotiation: negotiation,
+		Quantity:    quantity,
+		Charges:     charges,
+		Intent:      item.intent,
+		Selling:     item.intent == INTENT_SELL,
+	}
+}
+
+// Marshals the auction in the requested schema version
+func EncodeAuction(a Auction, version int) ([]byte, error) {
+	switch version {
+	case 1:
+		line := legacyAuctionLine{Line: a.Line(), Items: []legacyAuctionItem{}, Segments: a.Segments()}
+		for _, item := range a.Items {
+			name := strings.TrimSpace(item.Name)
+			line.Items = append(line.Items, legacyAuctionItem{Name: TitleCase(name, false), Uri: TitleCase(name, true)})
+		}
+		return json.Marshal(legacyAuctionPayload{Lines: []legacyAuctionLine{line}})
+	case 2:
+		return json.Marshal(NewAuctionEvent(a))
+	}
+
+	return nil, errors.New("Unsupported auction event version: " + fmt.Sprint(version))
+}
+
+// Returns the schema version a publisher has asked for, publishers which
+// haven't configured one get the current version
+func SchemaVersionFor(publisher string) int {
+	if version, ok := PUBLISHER_SCHEMA_VERSIONS[publisher]; ok {
+		return version
+	}
+
+	return AUCTION_EVENT_VERSION
+}
+
+// Stable id for an auction line, the same line seen by several collectors
+// hashes to the same id
+func (a *Auction) EventId() string {
+	h := fnv.New64a()
+	h.Write([]byte(a.Server + "|" + a.Timestamp.UTC().Format(time.RFC3339) + "|" + a.Seller + "|" + a.itemLine))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: AuctionSegment
+ |--------------------------------------------------------------------------
+ |
+ | A piece of an auction line, either plain text exactly as the player
+ | typed it or a reference to an item we matched.  Publishing the line as
+ | segments means consumers never have to insert markup into player text,
+ | each front end decides how to render an item itself.
+ |
+ | @member type (string): "text" or "item"
+ | @member text (string): The text from the line this segment covers
+ | @member item (*AuctionEventItem): The matched item, nil for text
+ |
+ */
+
+const SEGMENT_TEXT = "text"
+const SEGMENT_ITEM = "item"
+
+type AuctionSegment struct {
+	Type string            `json:"type"`
+	Text string            `json:"text"`
+	Item *AuctionEventItem `json:"item,omitempty"`
+}
+
+// Splits the item line into text and item segments.  Items are located in
+// the order the parser found them, an item whose text can't be found again
+// (the parser stripped a prefix or split a word) is left out of the segments
+// but is still listed on the event
+func (a *Auction) Segments() []AuctionSegment {
+	segments := []AuctionSegment{}
+	line := a.itemLine
+	lower := asciiLower(line)
+	cursor := 0
+
+	for _, item := range a.Items {
+		needle := asciiLower(strings.TrimSpace(item.matched))
+		if needle == "" {
+			needle = asciiLower(strings.TrimSpace(item.Name))
+		}
+		if needle == "" {
+			continue
+		}
+
+		index := strings.Index(lower[cursor:], needle)
+		if index < 0 {
+			continue
+		}
+
+		start := cursor + index
+		end := start + len(needle)
+		if start > cursor {
+			segments = append(segments, AuctionSegment{Type: SEGMENT_TEXT, Text: line[cursor:start]})
+		}
+
+		ref := newAuctionEventItem(item)
+		segments = append(segments, AuctionSegment{Type: SEGMENT_ITEM, Text: line[start:end], Item: &ref})
+		cursor = end
+	}
+
+	if cursor < len(line) {
+		segments = append(segments, AuctionSegment{Type: SEGMENT_TEXT, Text: line[cursor:]})
+	}
+
+	return segments
+}
+
+// Lower cases ASCII letters only so byte offsets into the result line up
+// with the original string
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+
+	return string(b)
+}
@@ -3,9 +3,9 @@ package main
 import (
 	"fmt"
 	"strings"
-	"github.com/bradfitz/gomemcache/memcache"
 	"strconv"
 	"time"
+	"unicode"
 )
 
 /*
@@ -16,8 +16,15 @@ import (
  | Represent an auction
  |
  | @member seller (string) : The name of the person selling this item
- | @member items ([]Item) : An array of WTS items associated with this specific auction
+ | @member items ([]Item) : An array of WTS, WTB and WTT items associated with this specific auction
  | @member auction_at (time.Time) : Timestamp of when this was auctioned
+ | @member zone (string) : Zone the uploading client was in
+ | @member label (string) : What sort of line this is, see classifier.go
+ | @member changes ([]ListingChanged) : Listings in this auction whose price, quantity or
+ |         intent changed since the seller last auctioned them, filled in when saving
+ | @member original ([]byte) : The line exactly as it was uploaded, before normalise.go
+ |         cleaned it up
+ | @member cleaned ([]string) : What normalise.go had to fix in the line, if anything
  |
  */
 
@@ -26,88 +33,147 @@ type Auction struct {
 	Timestamp time.Time
 	Items []Item
 	Server string
+	Zone string
+	Changes []ListingChanged
+	playerId int64
+	pendingQuantity int16
+	label string
+	trace *ParseTrace
 	itemLine string
 	raw string
+	original []byte
+	cleaned []string
 }
 
-func (a *Auction) ExtractQueryInformation(callback func(string, []interface{})) {
-	//fmt.Println("Saving auction for seller: " + a.Seller + ", with " + fmt.Sprint(len(a.Items)) + " items.")
+// Called by the price parser with a match on the buffer, a bare whole number
+// (or "10x") with no item before it, or after an item which already has a
+// price, is the quantity of the item which follows rather than a price.  It
+// is held until appendIfInCatalog picks it up
+func (a *Auction) holdsPendingQuantity(matches []string) bool {
+	prelimiter := strings.TrimSpace(strings.ToLower(matches[1]))
+	number := strings.TrimSpace(matches[2])
+	delimiter := strings.TrimSpace(strings.ToLower(matches[3]))
+	a.pendingQuantity = 0
+	if prelimiter != "" || strings.Contains(number, ".") {
+		return false
+	}
 
-	if a.Seller != "" && len(a.Items) > 0 {
-		playerId := a.GetPlayer()
-		LogInDebugMode("Player: " + strings.Title(a.Seller) + " has an id of: " + fmt.Sprint(playerId))
+	switch delimiter {
+	case "":
+		// "Ale 5" is still read as a price for the ale, as is "Ale 50" while we
+		// are still reading the same number
+		if len(a.Items) > 0 {
+			last := a.Items[len(a.Items)-1]
+			if last.statedCopper == 0 || (last.priceToken != "" && strings.HasPrefix(strings.TrimSpace(matches[0]), last.priceToken)) {
+				return false
+			}
+		}
+	case "x":
+		// "Ale 10x" is the ale's quantity
+		if len(a.Items) > 0 {
+			return false
+		}
+	default:
+		return false
+	}
 
-		// Get the items
-		itemsQuery := "SELECT id, displayName FROM items " +
-			"WHERE displayName IN ("
+	quantity, err := strconv.ParseInt(number, 10, 16)
+	if err != nil || quantity <= 0 {
+		return false
+	}
 
-		var params []string
-		var prices []float32
-		var quants []int32
-		var sellable []bool
-		for _, item := range a.Items {
-			itemsQuery += "?,"
-			params = append(params, strings.TrimSpace(item.Name))
-			prices = append(prices, item.Price)
-			sellable = append(sellable, item.selling)
-			if item.Quantity == 0 {
-				item.Quantity = 1
+	a.pendingQuantity = int16(quantity)
+	return true
+}
+
+// Returns the quantity written in front of the item being appended, or 1
+func (a *Auction) takePendingQuantity() int16 {
+	quantity := a.pendingQuantity
+	a.pendingQuantity = 0
+	if quantity <= 0 {
+		return 1
+	}
+
+	return quantity
+}
+
+// The auction as it appears in the log without the timestamp
+func (a *Auction) Line() string {
+	return a.Seller + " auctions, '" + a.itemLine + "'"
+}
+
+// Rough 0-1 measure of how much of the line the parser understood.  Half of
+// the score is the share of letters in the line covered by item names, the
+// other half is the share of items we found a price for
+func (a *Auction) ParseConfidence() float32 {
+	if len(a.Items) == 0 {
+		return 0
+	}
+
+	countLetters := func(s string) int {
+		n := 0
+		for _, r := range s {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				n++
 			}
-			quants = append(quants, int32(item.Quantity))
 		}
-		itemsQuery = itemsQuery[0:len(itemsQuery)-1] + ")" // remove the last ','
+		return n
+	}
 
-		// convert []string to []interface for query
-		convertedParams := make([]interface{}, len(params))
-		for i, v := range params {
-			convertedParams[i] = v
+	letters := countLetters(a.itemLine)
+	matched := 0
+	priced := 0
+	for _, item := range a.Items {
+		matched += countLetters(item.Name)
+		if item.Price > 0 {
+			priced++
 		}
+	}
 
-		//fmt.Println(convertedParams)
-		//fmt.Println(itemsQuery)
+	coverage := 1.0
+	if letters > 0 && matched < letters {
+		coverage = float64(matched) / float64(letters)
+	}
 
-		rows := DB.Query(itemsQuery, convertedParams...)
-		if rows != nil {
-			var itemId int64
-			var name string
+	return float32(0.5 * coverage + 0.5 * float64(priced) / float64(len(a.Items)))
+}
 
-			for rows.Next() {
-				err := rows.Scan(&itemId, &name)
-				if err != nil {
-					fmt.Println("Scan error: ", err)
-				} else {
-					for i, item := range a.Items {
-						//fmt.Println("Checking if: " + item.Name + " is equal to: " + name)
-						if strings.ToLower(strings.TrimSpace(item.Name)) == strings.ToLower(name) {
-							a.Items[i].id = itemId
-							//fmt.Println("Item: " + item.Name + " is equal to: " + name + " setting id to: " + fmt.Sprint(itemId))
-						}
-					}
-				}
-			}
-			if err := rows.Err(); err != nil {
-				fmt.Println("ROW ERROR: ", err.Error())
-			}
-			DB.CloseRows(rows)
-		}
+// The columns of an auctions row in the order auctionRow returns them
+const AUCTION_COLUMNS = "player_id, item_id, price, unit_price, lot_price, price_basis, price_min, price_max, negotiation, price_weight, quantity, charges, server, raw_auction, intent, parser_version"
+const AUCTION_ROW = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?),"
+
+func (a *Auction) ExtractQueryInformation(callback func(string, []interface{})) {
+	//fmt.Println("Saving auction for seller: " + a.Seller + ", with " + fmt.Sprint(len(a.Items)) + " items.")
+
+	if a.Seller != "" && len(a.Items) > 0 {
+		playerId := a.GetPlayer()
+		a.playerId = playerId
+		LogInDebugMode("Player: " + strings.Title(a.Seller) + " has an id of: " + fmt.Sprint(playerId))
+
+		a.lookupItemIds()
 
 		auctionQuery := ""
 
 		var auctionParams []interface{}
-		for i, item := range a.Items {
+		for _, item := range a.Items {
 			LogInDebugMode("Checking item: ", item.Name + " for seller: " + a.Seller)
-			if !a.itemRecentlyAuctionedByPlayer(item.id, prices[i], quants[i]) && item.id > 0 {
-				auctionQuery += "(?, ?, ?, ?, ?, ?, ?),"
-				auctionParams = append(auctionParams, playerId)
-				auctionParams = append(auctionParams, item.id)
-				auctionParams = append(auctionParams, prices[i])
-				auctionParams = append(auctionParams, quants[i])
-				auctionParams = append(auctionParams, a.Server)
-				//auctionParams = append(auctionParams, a.Timestamp)
-				auctionParams = append(auctionParams, (a.Seller + " auctions, '" + a.itemLine + "'"))
-				auctionParams = append(auctionParams, sellable[i])
-			} else if item.id <= 0 {
+			if item.id <= 0 {
 				LogInDebugMode("Item: ", item.Name + " does not have an id :(")
+				continue
+			}
+
+			recent, change := a.itemRecentlyAuctionedByPlayer(item.id, item.Price, int32(item.quantityOrOne()), item.intent, item.Charges)
+			if change != nil {
+				change.ItemName = item.displayName
+				change.playerId = playerId
+				change.Summary = change.Describe()
+				LogInDebugMode("Listing changed: ", change.Summary)
+				a.Changes = append(a.Changes, *change)
+			}
+
+			if !recent {
+				auctionQuery += AUCTION_ROW
+				auctionParams = append(auctionParams, a.auctionRow(item, playerId, ACTIVE_PARSER)...)
 			} else {
 				LogInDebugMode("Item: ", item.Name + " was recently sold")
 			}
@@ -122,46 +188,125 @@ func (a *Auction) ExtractQueryInformation(callback func(string, []interface{}))
 
 }
 
-// Check memcached to see whether or not this item was already recently auctioned, if it was
-// then we wont save its record out to the DB unless the price has changed
-func (a *Auction) itemRecentlyAuctionedByPlayer(itemId int64, price float32, quantity int32) bool {
+// Looks up the id and display name of every item in the auction, items which
+// aren't in the items table are left with an id of 0
+func (a *Auction) lookupItemIds() {
+	if len(a.Items) == 0 {
+		return
+	}
+
+	itemsQuery := "SELECT id, displayName FROM items " +
+		"WHERE displayName IN ("
+
+	var params []interface{}
+	for _, item := range a.Items {
+		itemsQuery += "?,"
+		params = append(params, strings.TrimSpace(item.Name))
+	}
+	itemsQuery = itemsQuery[0:len(itemsQuery)-1] + ")" // remove the last ','
 
-	var s Sale = Sale{Seller:a.Seller, ItemId: itemId, Price: price, Quantity: quantity}
+	rows := DB.Query(itemsQuery, params...)
+	if rows != nil {
+		var itemId int64
+		var name string
 
-	// Attempt to fetch the item from memached
-	mc := memcache.New(MC_HOST + ":" + MC_PORT)
+		for rows.Next() {
+			err := rows.Scan(&itemId, &name)
+			if err != nil {
+				fmt.Println("Scan error: ", err)
+			} else {
+				for i, item := range a.Items {
+					if strings.ToLower(strings.TrimSpace(item.Name)) == strings.ToLower(name) {
+						a.Items[i].id = itemId
+						a.Items[i].displayName = name
+					}
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			fmt.Println("ROW ERROR: ", err.Error())
+		}
+		DB.CloseRows(rows)
+	}
+}
+
+// The values of the auctions row for an item, see AUCTION_COLUMNS.  price is
+// kept for existing readers and holds the unit price
+func (a *Auction) auctionRow(item Item, playerId int64, parserVersion string) []interface{} {
+	var charges interface{}
+	if item.Charges > 0 {
+		charges = item.Charges
+	}
+
+	return []interface{}{
+		playerId, item.id, item.Price, item.Price, item.LotPrice, item.PriceBasis, item.PriceMin, item.PriceMax,
+		strings.Join(item.Negotiation, ","), item.PriceWeight, int32(item.quantityOrOne()), charges,
+		a.Server, a.Line(), item.intent, parserVersion,
+	}
+}
+
+// Check the dedup cache to see whether or not this item was already recently auctioned, if it was
+// then we wont save its record out to the DB unless the price, quantity, charges or sell/buy/trade
+// intent has changed, in which case the change is returned as well.
+// Both the first sighting and a change are claimed atomically so when several collectors see
+// the same auction only one of them inserts it (and reports the change)
+func (a *Auction) itemRecentlyAuctionedByPlayer(itemId int64, price float32, quantity int32, intent string, charges int16) (bool, *ListingChanged) {
+
+	var s Sale = Sale{Seller:a.Seller, ItemId: itemId, Price: price, Quantity: quantity, Intent: intent, Charges: charges}
+	ttl := time.Second * SALE_CACHE_TIME_IN_SECS
 
-	// Use an _ as we don't need to use the cache item returned
 	key := strings.TrimSpace("server:" + a.Server + ":sale:" + strconv.FormatInt(itemId, 10) + ":player:" + a.Seller)
-	//fmt.Println("Key is: ", key)
-	mcItem, err := mc.Get(key)
-	if err != nil {
-		if err.Error() == "memcache: cache miss" {
-			//fmt.Println("Couldn't find item in the cache")
-			LogInDebugMode("Setting item: " + key + " in cache for: " + fmt.Sprint(SALE_CACHE_TIME_IN_SECS) + " seconds")
-			mc.Set(&memcache.Item{Key: fmt.Sprint(key), Value: s.serialize(), Expiration: SALE_CACHE_TIME_IN_SECS})
-			return false
-		} else {
+	for attempt := 0; attempt < DEDUP_CLAIM_ATTEMPTS; attempt++ {
+		entry, err := Dedup.GetForUpdate(key)
+		if err != nil {
 			fmt.Println("Error was: ", err.Error())
-			return false
+			return false, nil
 		}
-	} else if mcItem != nil {
-		LogInDebugMode("Got item from memcached: ", mcItem)
-		var s Sale
-		s = s.deserialize(mcItem.Value)
-
-		if s.Price == price {
-			LogInDebugMode("The prices haven't changed so we will not insert for id: ", itemId)
-			return true
+
+		if entry == nil {
+			added, err := Dedup.Add(key, s.serialize(), ttl)
+			if err != nil {
+				fmt.Println("Error was: ", err.Error())
+				return false, nil
+			}
+			if added {
+				LogInDebugMode("Claimed item: " + key + " in cache for: " + fmt.Sprint(SALE_CACHE_TIME_IN_SECS) + " seconds")
+				return false, nil
+			}
+			// Another collector claimed it between our read and add, read it again
+			continue
 		}
 
-		LogInDebugMode("The old price of: " + fmt.Sprint(s.Price) + " is different to: " + fmt.Sprint(price) + " busting the cache!")
-		s.Price = price
-		mc.Replace(&memcache.Item{Key: fmt.Sprint(key), Value: s.serialize(), Expiration: SALE_CACHE_TIME_IN_SECS})
-		return false
+		var cached Sale
+		cached = cached.deserialize(entry.Value)
+
+		if cached.state() == s.state() {
+			LogInDebugMode("The listing hasn't changed so we will not insert for id: ", itemId)
+			return true, nil
+		}
+
+		LogInDebugMode("The old listing of: " + fmt.Sprint(cached.state()) + " is different to: " + fmt.Sprint(s.state()) + " busting the cache!")
+		swapped, err := Dedup.CompareAndSwap(key, entry, s.serialize(), ttl)
+		if err != nil {
+			fmt.Println("Error was: ", err.Error())
+			return false, nil
+		}
+		if swapped {
+			return false, &ListingChanged{
+				Server: a.Server,
+				Seller: a.Seller,
+				ItemId: itemId,
+				Old: cached.state(),
+				New: s.state(),
+				Timestamp: a.Timestamp,
+			}
+		}
+		// The value changed underneath us, read it again and see if our listing is still new
 	}
 
-	return false
+	// Other collectors kept winning the claim, one of them will have inserted it
+	LogInDebugMode("Lost the claim for item: ", key)
+	return true, nil
 }
 
 // Attempt to create the player, if they already exist then we select them from the DB
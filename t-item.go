@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"strings"
-	"regexp"
 	"strconv"
 )
 
@@ -19,21 +18,103 @@ import (
  | @member name (string): Name of the item (url encoded)
  | @member displayName (string): Name of the item (browser friendly)
  | @member imageSrc (string): URL for the image stored on wiki
- | @member price (float32): The advertised price
+ | @member price (float32): The advertised price for a single unit
+ | @member lotPrice (float32): The advertised price for the whole quantity
+ | @member priceBasis (string): Whether the seller stated a unit price ("ea",
+ |         "each", "per") or a price for the whole lot
+ | @member priceMin/priceMax (float32): Both ends of a unit price given as a
+ |         range ("4-5k"), both equal price otherwise
+ | @member negotiation ([]string): obo, firm, offers and pst flags, see negotiation.go
+ | @member priceWeight (float32): How much the price should count towards
+ |         price statistics, from PRICE_STATISTIC_WEIGHTS
+ | @member intent (string): Whether the seller is selling, buying or
+ |         looking to trade the item
+ | @member charges (int16): Charges left on the item, 0 when not given
  | @member statistics ([]Statistic): An array of all stats for this item
  |
  */
 
+const PRICE_BASIS_UNIT = "unit"
+const PRICE_BASIS_LOT = "lot"
+
+// What the seller wants to do with an item, "WTS", "WTB" or "WTT"
+const INTENT_SELL = "sell"
+const INTENT_BUY = "buy"
+const INTENT_TRADE = "trade"
+
 type Item struct {
 	Name string
 	Price float32
+	LotPrice float32
+	PriceBasis string
+	PriceMin float32
+	PriceMax float32
+	Negotiation []string
+	PriceWeight float32
 	Quantity int16
-	selling bool
+	Charges int16
+	intent string
 	id int64
+	displayName string // name from the items table, set once the id is looked up
+	matched string // the text from the line that matched, before any spell/rune prefix was added
+	statedCopper int64 // the price as written in copper, see PriceBasis for what it covers
+	minCopper int64 // the lower end of a price range in copper, 0 when the price isn't a range
+	priceToken string // the text the stated price was read from
+}
+
+// Works out the unit and lot prices from the stated price once the quantity
+// is known.  A price without "ea"/"each"/"per" is taken to be for the whole
+// lot, so "10 Bone Chips 10p" and "10 Bone Chips 1p ea" both give a unit
+// price of 1p
+func (i *Item) ResolvePrices() {
+	quantity := float32(i.Quantity)
+	if quantity < 1 {
+		quantity = 1
+	}
+
+	stated := CopperToPlatinum(i.statedCopper)
+	if i.PriceBasis == "" {
+		i.PriceBasis = PRICE_BASIS_LOT
+	}
+	if i.PriceBasis == PRICE_BASIS_UNIT {
+		i.Price = stated
+		i.LotPrice = stated * quantity
+	} else {
+		i.Price = stated / quantity
+		i.LotPrice = stated
+	}
+
+	// The top of a range is the asking price, the bottom is scaled the same way
+	i.PriceMin = i.Price
+	i.PriceMax = i.Price
+	if i.minCopper > 0 && i.statedCopper > 0 {
+		i.PriceMin = i.Price * float32(i.minCopper) / float32(i.statedCopper)
+	}
+	i.PriceWeight = PriceWeightFor(i)
+}
+
+// The quantity stored for the item, an item without one is a single item
+func (i *Item) quantityOrOne() int16 {
+	if i.Quantity <= 0 {
+		return 1
+	}
+
+	return i.Quantity
+}
+
+// Records a negotiation flag against the item, once
+func (i *Item) AddNegotiationFlag(flag string) {
+	for _, existing := range i.Negotiation {
+		if existing == flag {
+			return
+		}
+	}
+	i.Negotiation = append(i.Negotiation, flag)
 }
 
 // This method should be fairly self explanatory.  We simply use a regex to
-// extract matches from the input string and then write the data back out
+// extract matches from the input string, any denomination after the number
+// is looked up in DENOMINATIONS and the price is kept in copper, and then write the data back out
 // to the last item on the input struct (this assumes that meta info is in
 // the order of ITEM QUANTITY PRICE or ITEM PRICE QUANTITY etc.
 // if the order is QUANTITY ITEM PRICE then the quantity will be assigned to
@@ -43,54 +124,176 @@ type Item struct {
 // of prices for previously parsed items when the legnth is 0, as we could then
 // assume that the rest of the items would follow the same pattern in that string...(TODO?)
 func (i *Item) ParsePriceAndQuantity(buffer *[]byte, auction *Auction) bool {
-	//fmt.Println("Parsing: " + string(*buffer) + " for price data")
-	price_regex := regexp.MustCompile(`(?im)^(x ?)?(\d*\.?\d*)(k|p|pp| ?x)?$`)
 	price_string := strings.TrimSpace(string(*buffer))
 
-	matches := price_regex.FindStringSubmatch(price_string)
-	if len(matches) > 1 && len(strings.TrimSpace(matches[0])) > 0 && len(auction.Items) > 0 {
-		matches = matches[1:]
-		price, err := strconv.ParseFloat(strings.TrimSpace(matches[1]), 64)
-		if err != nil {
-			fmt.Println("error setting for string: " + strings.TrimSpace(matches[1]) + ", price: ", err)
-			price = 0.0
-		}
-		var prelimiter string = strings.TrimSpace(strings.ToLower(matches[0]))
-		var delimiter string = strings.ToLower(matches[2])
-		var multiplier float64 = 1.0;
-		var isQuantity bool = false
-
-		switch delimiter {
-		case "x": isQuantity = true; break;
-		case "p": multiplier = 1.0; break;
-		case "k": multiplier = 1000.0; break;
-		case "pp": multiplier = 1.0; break;
-		case "m": multiplier = 1000000.0; break;
-		default: multiplier = 1; break;
+	// The start of a charge count, see charges.go
+	if price_string == CHARGES_MARKER {
+		return len(auction.Items) > 0
+	}
+
+	// Every price starts with a number, "x" or the charges marker, the regex is
+	// only run on buffers that could be one
+	if price_string == "" || !strings.ContainsRune("0123456789.x#", rune(price_string[0])) {
+		auction.trace.Add(TRACE_PRICE_MISS, *buffer, "")
+		return false
+	}
+	matches := priceRegex.FindStringSubmatch(price_string)
+	if len(matches) <= 1 || len(strings.TrimSpace(matches[0])) == 0 || strings.TrimSpace(matches[2]) == "" {
+		auction.trace.Add(TRACE_PRICE_MISS, *buffer, "")
+		return false
+	}
+	if auction.trace != nil {
+		auction.trace.Add(TRACE_PRICE_MATCH, *buffer, fmt.Sprintf("%q", matches[1:]))
+	}
+	if strings.TrimSpace(matches[1]) == CHARGES_MARKER {
+		return i.parseCharges(matches, auction)
+	}
+	if matches[4] != "" {
+		return i.parsePriceRange(price_string, matches, auction)
+	}
+
+	var prelimiter string = strings.TrimSpace(strings.ToLower(matches[1]))
+	var delimiter string = strings.TrimSpace(strings.ToLower(matches[3]))
+	var copperPerUnit int64 = COPPER_PER_PLATINUM
+	var isQuantity bool = delimiter == "x" || prelimiter == "x"
+
+	if !isQuantity && delimiter != "" {
+		copper, partial, ok := LookupDenomination(delimiter)
+		if partial {
+			// e.g. "50g", keep the buffer until we know if it is "50gp"
+			return true
+		} else if !ok {
+			return false
 		}
+		copperPerUnit = copper
+	} else if !isQuantity && bareDecimalRegex.MatchString(price_string) {
+		copperPerUnit, _, _ = LookupDenomination(BARE_DECIMAL_DENOMINATION)
+	}
+
+	if auction.holdsPendingQuantity(matches) {
+		// A quantity written in front of the next item, e.g. "10 Bone Chips"
+		return true
+	}
+	if len(auction.Items) == 0 {
+		return false
+	}
+
+	// Not a number yet, e.g. a lone "."
+	price, err := strconv.ParseFloat(strings.TrimSpace(matches[2]), 64)
+	if err != nil {
+		price = 0.0
+	}
 
-		if prelimiter == "x" {
-			isQuantity = true
+	// check if this was in-fact quantity data
+	var item *Item = &auction.Items[len(auction.Items)-1]
+	if isQuantity && price > 0.0 {
+		// A quantity too big for the column is dropped rather than wrapped, along
+		// with what the shorter readings of it ("x4000" of "x40000") set
+		quantity, err := strconv.ParseInt(strings.TrimSpace(matches[2]), 10, 16)
+		if err != nil || quantity <= 0 {
+			quantity = 1
 		}
+		item.Quantity = int16(quantity)
+	} else if price > 0.0 {
+		// We see the price a character at a time ("5", "50", "50g", "50gp") so
+		// while we are still reading the same price the latest reading wins,
+		// otherwise a second price for the item only counts if it is higher
+		copper := ToCopper(price, copperPerUnit)
+		sameToken := item.priceToken != "" && strings.HasPrefix(price_string, item.priceToken)
+		if sameToken || copper > item.statedCopper {
+			item.statedCopper = copper
+			item.minCopper = 0
+			item.priceToken = price_string
+		}
+	}
+
+	return true
+}
 
-		// check if we need to set a new multiplier
-		price_without_delim_regex := regexp.MustCompile(`(?im)^([0-9]{1,}\.[0-9]{1,})$`)
-		matches = price_without_delim_regex.FindAllString(price_string, -1)
-		if len(matches) > 0 {
-			multiplier = 1000.0
+// Reads the charge count written as "#5" by NormaliseCharges for the last item
+func (i *Item) parseCharges(matches []string, auction *Auction) bool {
+	if len(auction.Items) == 0 || strings.TrimSpace(matches[3]) != "" || matches[4] != "" {
+		return false
+	}
+
+	charges, err := strconv.ParseInt(strings.TrimSpace(matches[2]), 10, 16)
+	if err != nil || charges <= 0 {
+		return false
+	}
+	auction.Items[len(auction.Items)-1].Charges = int16(charges)
+
+	return true
+}
+
+// Reads a price range such as "4-5k", "4k-5k" or "1.5-2" for the last item.
+// A denomination written on only one end applies to both and the upper end
+// is taken as the asking price, the lower end is kept in minCopper
+func (i *Item) parsePriceRange(price_string string, matches []string, auction *Auction) bool {
+	if len(auction.Items) == 0 || strings.TrimSpace(matches[1]) != "" {
+		return false
+	}
+	if strings.TrimSpace(matches[5]) == "" {
+		// "4-", keep reading until we have the other end
+		return true
+	}
+
+	var lowerPerUnit, upperPerUnit int64
+	if delimiter := strings.TrimSpace(strings.ToLower(matches[3])); delimiter != "" {
+		copper, _, ok := LookupDenomination(delimiter)
+		if !ok {
+			return false
+		}
+		lowerPerUnit = copper
+	}
+	if delimiter := strings.TrimSpace(strings.ToLower(matches[6])); delimiter != "" {
+		copper, partial, ok := LookupDenomination(delimiter)
+		if partial {
+			return true
+		} else if !ok {
+			return false
 		}
+		upperPerUnit = copper
+	}
 
-		// check if this was in-fact quantity data
-		var item *Item = &auction.Items[len(auction.Items)-1]
-		if isQuantity == true && price > 0.0 {
-			//fmt.Println("setting quantity: ", fmt.Sprint(int16(price)))
-			item.Quantity = int16(price)
-		} else if price > 0.0 && float32(price * multiplier) > item.Price {
-			item.Price = float32((price * multiplier) / float64(item.Quantity))
+	if lowerPerUnit == 0 {
+		lowerPerUnit = upperPerUnit
+	}
+	if upperPerUnit == 0 {
+		upperPerUnit = lowerPerUnit
+	}
+	if upperPerUnit == 0 {
+		upperPerUnit = COPPER_PER_PLATINUM
+		if strings.Contains(matches[2] + matches[5], ".") {
+			upperPerUnit, _, _ = LookupDenomination(BARE_DECIMAL_DENOMINATION)
 		}
+		lowerPerUnit = upperPerUnit
+	}
 
-		return true
+	lower, err := strconv.ParseFloat(strings.TrimSpace(matches[2]), 64)
+	if err != nil {
+		return false
+	}
+	upper, err := strconv.ParseFloat(strings.TrimSpace(matches[5]), 64)
+	if err != nil {
+		return false
+	}
+
+	lowerCopper := ToCopper(lower, lowerPerUnit)
+	upperCopper := ToCopper(upper, upperPerUnit)
+	if lowerCopper > upperCopper {
+		lowerCopper, upperCopper = upperCopper, lowerCopper
+	}
+	if upperCopper <= 0 {
+		return false
+	}
+
+	var item *Item = &auction.Items[len(auction.Items)-1]
+	sameToken := item.priceToken != "" && strings.HasPrefix(price_string, item.priceToken)
+	if sameToken || upperCopper > item.statedCopper {
+		item.statedCopper = upperCopper
+		item.minCopper = lowerCopper
+		item.priceToken = price_string
 	}
 
-	return false
+	return true
 }
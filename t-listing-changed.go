@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ListingChanged
+ |--------------------------------------------------------------------------
+ |
+ | Records a seller changing the price, quantity, charges or sell/buy/trade
+ | intent of an item they keep auctioning.  The last state of every (server, seller,
+ | item) listing is kept in the dedup cache, when a new sighting differs
+ | from it one of these is produced, saved to listing_changes and
+ | published on the auction event so users can be alerted.
+ |
+ | @member server (string): RED or BLUE
+ | @member seller (string): Name of the player auctioning
+ | @member itemId (int64): Id of the item in the items table
+ | @member itemName (string): Display name of the item
+ | @member old (ListingState): What the seller was asking before
+ | @member new (ListingState): What the seller is asking now
+ | @member timestamp (time.Time): Log timestamp of the auction with the change
+ |
+ */
+
+type ListingState struct {
+	Price    float32 `json:"price"`
+	Quantity int32   `json:"quantity"`
+	Intent   string  `json:"intent"`
+	Charges  int16   `json:"charges,omitempty"` // charges left on a wand or rod, 0 when not given
+	Selling  bool    `json:"selling"` // intent == "sell", kept for version 2 consumers
+}
+
+type ListingChanged struct {
+	Server    string       `json:"server"`
+	Seller    string       `json:"seller"`
+	ItemId    int64        `json:"itemId"`
+	ItemName  string       `json:"itemName"`
+	Old       ListingState `json:"old"`
+	New       ListingState `json:"new"`
+	Timestamp time.Time    `json:"timestamp"`
+	Summary   string       `json:"summary"`
+	playerId  int64
+}
+
+// Names of the parts of the listing which changed
+func (l *ListingChanged) Fields() []string {
+	var fields []string
+	if l.Old.Price != l.New.Price {
+		fields = append(fields, "price")
+	}
+	if l.Old.Quantity != l.New.Quantity {
+		fields = append(fields, "quantity")
+	}
+	if l.Old.Intent != l.New.Intent {
+		fields = append(fields, "intent")
+	}
+	if l.Old.Charges != l.New.Charges {
+		fields = append(fields, "charges")
+	}
+
+	return fields
+}
+
+// Describes the change for an alert, e.g. "Soandso dropped Cloak of Flames from 3k to 2.5k"
+func (l *ListingChanged) Describe() string {
+	name := TitleCase(strings.TrimSpace(l.ItemName), false)
+	var parts []string
+
+	if l.Old.Intent != l.New.Intent {
+		switch l.New.Intent {
+		case INTENT_SELL:
+			parts = append(parts, "is now selling " + name)
+		case INTENT_BUY:
+			parts = append(parts, "is now buying " + name)
+		case INTENT_TRADE:
+			parts = append(parts, "is now trading " + name)
+		}
+	}
+
+	if l.Old.Price != l.New.Price {
+		verb := "raised"
+		if l.New.Price < l.Old.Price {
+			verb = "dropped"
+		}
+		parts = append(parts, verb + " " + name + " from " + FormatPlatinum(l.Old.Price) + " to " + FormatPlatinum(l.New.Price))
+	}
+
+	if l.Old.Quantity != l.New.Quantity {
+		parts = append(parts, "changed the quantity of " + name + " from " + fmt.Sprint(l.Old.Quantity) + " to " + fmt.Sprint(l.New.Quantity))
+	}
+
+	if l.Old.Charges != l.New.Charges {
+		parts = append(parts, "changed the charges on " + name + " from " + fmt.Sprint(l.Old.Charges) + " to " + fmt.Sprint(l.New.Charges))
+	}
+
+	return l.Seller + " " + strings.Join(parts, " and ")
+}
+
+// Formats a platinum price the way players write them, 2500 becomes 2.5k
+func FormatPlatinum(price float32) string {
+	if price >= 1000 {
+		return strconv.FormatFloat(float64(price) / 1000, 'f', -1, 32) + "k"
+	}
+
+	return strconv.FormatFloat(float64(price), 'f', -1, 32) + "p"
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ParseTrace
+ |--------------------------------------------------------------------------
+ |
+ | A step by step record of how the parser read a single line, used to
+ | work out why a line was misparsed without adding print statements.
+ | Attach one to an auction before calling extractItems and every buffer
+ | state, trie prefix hit or miss, skipped character, price match and item
+ | is recorded against the character being read.  A nil trace records
+ | nothing so the parser calls it unconditionally.
+ |
+ | Available from POST /debug/parse and the "explain" command.
+ |
+ | @member input (string): The line as given
+ | @member cleaned ([]string): What normalise.go fixed in the line before it
+ |         was read (encoding, line_ending, whitespace, apostrophe, control)
+ | @member text (string): The text inside the quotes
+ | @member normalised (string): The text after charges, denominations and
+ |         ranges were rewritten, this is what the steps index into
+ | @member label (string): What the classifier made of the line, only
+ |         listings (and price checks, as demand) would be stored
+ | @member steps ([]ParseStep): Everything the parser did, in order
+ | @member items ([]AuctionEventItem): The items the line produced
+ | @member error (string): Why the line couldn't be read, if it couldn't
+ |
+ */
+
+const (
+	TRACE_INTENT        = "intent"
+	TRACE_NEGOTIATION   = "negotiation"
+	TRACE_UNIT_PRICE    = "unit_price"
+	TRACE_SKIPPED_CHAR  = "skipped_char"
+	TRACE_PREFIX_HIT    = "prefix_hit"
+	TRACE_PREFIX_MISS   = "prefix_miss"
+	TRACE_BUFFER_RESET  = "buffer_reset"
+	TRACE_ITEM          = "item"
+	TRACE_NO_ITEM       = "no_item"
+	TRACE_PRICE_MATCH   = "price_match"
+	TRACE_PRICE_MISS    = "price_miss"
+)
+
+type ParseStep struct {
+	Index  int    `json:"index"`
+	Char   string `json:"char"`
+	Buffer string `json:"buffer"`
+	Event  string `json:"event"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type ParseTrace struct {
+	Input      string             `json:"input"`
+	Cleaned    []string           `json:"cleaned,omitempty"`
+	Text       string             `json:"text"`
+	Normalised string             `json:"normalised"`
+	Label      string             `json:"label"`
+	Steps      []ParseStep        `json:"steps"`
+	Items      []AuctionEventItem `json:"items"`
+	Error      string             `json:"error,omitempty"`
+	index      int
+	char       rune
+}
+
+// Moves the trace on to the character being read
+func (t *ParseTrace) At(index int, char rune) {
+	if t == nil {
+		return
+	}
+	t.index = index
+	t.char = char
+}
+
+// Records a step against the current character
+func (t *ParseTrace) Add(event string, buffer []byte, detail string) {
+	if t == nil {
+		return
+	}
+	t.Steps = append(t.Steps, ParseStep{Index: t.index, Char: string(t.char), Buffer: string(buffer), Event: event, Detail: detail})
+}
+
+// Plain text version of the trace for the command line
+func (t *ParseTrace) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "input:      %s\n", t.Input)
+	if len(t.Cleaned) > 0 {
+		fmt.Fprintf(&out, "cleaned:    %s\n", strings.Join(t.Cleaned, ", "))
+	}
+	if t.Error != "" {
+		fmt.Fprintf(&out, "error:      %s\n", t.Error)
+		return out.String()
+	}
+	fmt.Fprintf(&out, "text:       %s\n", t.Text)
+	fmt.Fprintf(&out, "normalised: %s\n", t.Normalised)
+	fmt.Fprintf(&out, "label:      %s\n\n", t.Label)
+
+	for _, step := range t.Steps {
+		fmt.Fprintf(&out, "%4d %-3q %-24q %-16s %s\n", step.Index, step.Char, step.Buffer, step.Event, step.Detail)
+	}
+
+	out.WriteString("\n")
+	for _, item := range t.Items {
+		fmt.Fprintf(&out, "item: %s unit=%s lot=%s basis=%s quantity=%d intent=%s\n",
+			item.Name, FormatPlatinum(item.Price), FormatPlatinum(item.LotPrice), item.PriceBasis, item.Quantity, item.Intent)
+	}
+
+	return out.String()
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
 /*
  |-------------------------------------------------------------------------
  | Type: RawAuctions
@@ -9,10 +15,49 @@ package main
  |
  | @member zone (string): The name of the zone that the user is streaming from
  | @member lines ([]string): An array of strings containing the auction data
+ | @member received (time.Time): When the upload reached us, by our clock
+ | @member clientZone (*time.Location): The client's UTC offset from the
+ |         utcOffset header, nil when the client didn't send it
  |
  */
 
 type RawAuctions struct {
 	Zone string
 	Lines []string
-}
\ No newline at end of file
+	received time.Time
+	clientZone *time.Location
+}
+
+// The furthest any timezone is from UTC
+const MAX_CLOCK_OFFSET = 14 * time.Hour
+
+// Reads a UTC offset such as "-05:00", "+05:30" or "Z" sent by the client
+func ParseUTCOffset(value string) (*time.Location, error) {
+	t, err := time.Parse("Z07:00", strings.TrimSpace(value))
+	if err != nil {
+		return nil, errors.New("Please send utcOffset as +hh:mm or -hh:mm, you sent: " + value)
+	}
+	_, offset := t.Zone()
+	if time.Duration(offset) * time.Second > MAX_CLOCK_OFFSET || time.Duration(offset) * time.Second < -MAX_CLOCK_OFFSET {
+		return nil, errors.New("utcOffset is out of range: " + value)
+	}
+
+	return time.FixedZone(value, offset), nil
+}
+
+// The log timestamps are the client's wall clock with no timezone, so the same
+// line sent by clients in two timezones would be hours apart.  The difference
+// between the client's UTC offset and ours, added to each line's timestamp,
+// gives the time on our clock.  How old the lines are says nothing about the
+// client's timezone (a late upload of an old log looks the same as a client
+// hours behind us), so without the utcOffset header the timestamps are left
+// as they are
+func (r *RawAuctions) ClockOffset() time.Duration {
+	if r.clientZone == nil || r.received.IsZero() {
+		return 0
+	}
+
+	_, ours := r.received.Zone()
+	_, theirs := r.received.In(r.clientZone).Zone()
+	return time.Duration(ours - theirs) * time.Second
+}
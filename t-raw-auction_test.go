@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// The line as uploaded live and again three hours later from the same log,
+// received on a clock in UTC
+func oldLogUpload(t *testing.T, utcOffset string) (RawAuctions, RawAuctions) {
+	line := "[Mon Oct 19 12:00:00 2026] Soandso auctions, 'WTS Ale 5p'"
+	logged := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	live := RawAuctions{Lines: []string{line}, received: logged.Add(2 * time.Second)}
+	late := RawAuctions{Lines: []string{line}, received: logged.Add(3 * time.Hour)}
+	if utcOffset != "" {
+		zone, err := ParseUTCOffset(utcOffset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		live.clientZone, late.clientZone = zone, zone
+	}
+
+	return live, late
+}
+
+// How parse stamps the first line of the upload
+func stampedLine(t *testing.T, upload RawAuctions) Auction {
+	auction := Auction{Server: "BLUE"}
+	if err := AC.extractParserInformationFromLine(upload.Lines[0], &auction); err != nil {
+		t.Fatal(err)
+	}
+	auction.Timestamp = auction.Timestamp.Add(upload.ClockOffset())
+
+	return auction
+}
+
+func TestOldLogUploadIsMergedWithTheOriginalSighting(t *testing.T) {
+	configured := Dedup
+	defer func() { Dedup = configured }()
+
+	for _, utcOffset := range []string{"", "Z"} {
+		Dedup = NewMemoryDedupCache(100)
+		live, late := oldLogUpload(t, utcOffset)
+
+		first := stampedLine(t, live)
+		again := stampedLine(t, late)
+		if !again.Timestamp.Equal(first.Timestamp) {
+			t.Errorf("utcOffset %q: the late upload was stamped %s, the original %s", utcOffset, again.Timestamp, first.Timestamp)
+		}
+
+		line := first.Line()
+		if !AC.shouldParse(&line, first.Server, first.Timestamp) {
+			t.Fatalf("utcOffset %q: the original sighting wasn't parsed", utcOffset)
+		}
+		line = again.Line()
+		if AC.shouldParse(&line, again.Server, again.Timestamp) {
+			t.Errorf("utcOffset %q: the late upload was parsed as a new sighting", utcOffset)
+		}
+	}
+}
+
+func TestClockOffsetMovesTheClientZoneOntoOurs(t *testing.T) {
+	live, late := oldLogUpload(t, "-05:00")
+	for _, upload := range []RawAuctions{live, late} {
+		if offset := upload.ClockOffset(); offset != 5 * time.Hour {
+			t.Errorf("expected a -05:00 client to be moved 5h onto a UTC clock, got %s", offset)
+		}
+	}
+
+	for _, utcOffset := range []string{"5", "+15:00", "EST"} {
+		if _, err := ParseUTCOffset(utcOffset); err == nil {
+			t.Errorf("expected utcOffset %q to be refused", utcOffset)
+		}
+	}
+}
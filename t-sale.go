@@ -11,6 +11,12 @@ type Sale struct {
 	ItemId int64
 	Price float32
 	Quantity int32
+	Intent string
+	Charges int16
+}
+
+func (s *Sale) state() ListingState {
+	return ListingState{Price: s.Price, Quantity: s.Quantity, Intent: s.Intent, Charges: s.Charges, Selling: s.Intent == INTENT_SELL}
 }
 
 func (s *Sale) serialize() []byte {
@@ -31,6 +37,17 @@ func (s *Sale) deserialize(bytes []byte) Sale {
 		fmt.Println("Error when unmarshaling sale data: ", err)
 	}
 
+	// Sales cached before intents were added only have a Selling flag
+	if sale.Intent == "" {
+		var legacy struct{ Selling bool }
+		if json.Unmarshal(bytes, &legacy) == nil {
+			sale.Intent = INTENT_BUY
+			if legacy.Selling {
+				sale.Intent = INTENT_SELL
+			}
+		}
+	}
+
 	LogInDebugMode("Unmarshaled sale data: ", sale)
 	return sale
 }
\ No newline at end of file